@@ -0,0 +1,76 @@
+package z
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var redirectTemplate = template.Must(template.New("redirect").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{ .title }}</title>
+<link rel="canonical" href="{{ .url }}">
+<meta http-equiv="refresh" content="0; url={{ .url }}">
+</head>
+<body><a href="{{ .url }}">{{ .title }}</a></body>
+</html>
+`))
+
+// aliases returns the output paths (relative to the output directory) of the
+// comma-separated "aliases" page variable. Paths ending with a slash get an
+// index.html, paths outside of the output directory are rejected.
+func aliases(vars Vars) ([]string, error) {
+	paths := []string{}
+	for _, alias := range strings.Split(vars["aliases"], ",") {
+		alias = strings.TrimSpace(alias)
+		if alias == "" {
+			continue
+		}
+		if strings.HasSuffix(alias, "/") {
+			alias = alias + "index.html"
+		}
+		alias = path.Clean(strings.TrimPrefix(alias, "/"))
+		if alias == ".." || strings.HasPrefix(alias, "../") {
+			return nil, fmt.Errorf("alias outside of the site: %s", alias)
+		}
+		paths = append(paths, alias)
+	}
+	return paths, nil
+}
+
+// buildAliases writes a redirect page to the page url for every alias of the
+// page, so that the old urls of moved pages keep working
+func buildAliases(vars Vars) error {
+	paths, err := aliases(vars)
+	if err != nil {
+		return err
+	}
+	for _, alias := range paths {
+		if ok, err := claimOutput(alias, vars["file"], vars); err != nil {
+			return err
+		} else if !ok {
+			continue
+		}
+		out := filepath.Join(pubDir, filepath.FromSlash(alias))
+		if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+			return err
+		}
+		// Redirect relative to the alias location, like the page "root"
+		url := strings.Repeat("../", strings.Count(alias, "/")) + filepath.ToSlash(vars["url"])
+		f, err := os.Create(out)
+		if err != nil {
+			return err
+		}
+		err = redirectTemplate.Execute(f, map[string]string{"title": vars["title"], "url": url})
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package z
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAliases(t *testing.T) {
+	defer chdirTemp(t)()
+
+	os.MkdirAll(filepath.Join(PUBDIR, "posts"), 0755)
+	os.Mkdir("posts", 0755)
+	os.Mkdir(ZSDIR, 0755)
+	ioutil.WriteFile(ZSDIR+"/layout.amber", []byte("div #{unescaped(content)}"), 0644)
+	ioutil.WriteFile("posts/new.md", []byte("title: New\naliases: old/path.html, /older.html\n---\nMoved"), 0644)
+
+	if err := build("posts/new.md", nil, Vars{}); err != nil {
+		t.Fatal(err)
+	}
+	for alias, url := range map[string]string{
+		"old/path.html": "../posts/new.html",
+		"older.html":    "posts/new.html",
+	} {
+		b, err := ioutil.ReadFile(filepath.Join(PUBDIR, alias))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		s := string(b)
+		if !strings.Contains(s, `<meta http-equiv="refresh" content="0; url=`+url+`">`) ||
+			!strings.Contains(s, `<link rel="canonical" href="`+url+`">`) {
+			t.Error(alias, s)
+		}
+	}
+
+	if err := cleanStale(); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"posts/new.html", "old/path.html", "older.html"} {
+		if _, err := os.Stat(filepath.Join(PUBDIR, path)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err := aliases(Vars{"aliases": "../outside.html"}); err == nil {
+		t.Error("alias outside of the site accepted")
+	}
+}
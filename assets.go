@@ -0,0 +1,85 @@
+package z
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// assets maps asset paths (relative to the output directory) to their
+// fingerprinted paths, e.g. "style.css" to "style.a1b2c3d4.css"
+var assets = struct {
+	sync.Mutex
+	paths map[string]string
+}{paths: map[string]string{}}
+
+// Content hash in the name of a fingerprinted asset
+var fingerprintRe = regexp.MustCompile(`\.[0-9a-f]{8}$`)
+
+// fingerprinted returns true if the output of the given source file gets a
+// content hash in its name. It's enabled with ZS_FINGERPRINT=1 for CSS and
+// JavaScript files.
+func fingerprinted(path string, vars Vars) bool {
+	if vars["fingerprint"] != "1" {
+		return false
+	}
+	ext := filepath.Ext(outputPath(path, vars))
+	return ext == ".css" || ext == ".js"
+}
+
+// fingerprint writes the asset content into the output directory under the
+// name with a content hash and records it in the asset map. Existing files are
+// not rewritten, so their modification time only changes with the content.
+func fingerprint(name string, content []byte) error {
+	name = filepath.ToSlash(name)
+	sum := sha256.Sum256(content)
+	ext := filepath.Ext(name)
+	hashed := renameExt(name, ext, "."+hex.EncodeToString(sum[:4])+ext)
+	out := filepath.Join(pubDir, hashed)
+	if _, err := os.Stat(out); err != nil {
+		if err := ioutil.WriteFile(out, content, 0644); err != nil {
+			return err
+		}
+	}
+
+	assets.Lock()
+	defer assets.Unlock()
+	if old, ok := assets.paths[name]; ok && old != hashed {
+		os.Remove(filepath.Join(pubDir, old))
+	}
+	assets.paths[name] = hashed
+	return nil
+}
+
+// fingerprints returns the output paths of all the fingerprinted assets
+func fingerprints() []string {
+	assets.Lock()
+	defer assets.Unlock()
+	paths := []string{}
+	for _, hashed := range assets.paths {
+		paths = append(paths, filepath.Join(pubDir, hashed))
+	}
+	return paths
+}
+
+// asset returns the fingerprinted path of the given asset, or the path as is
+// if the asset is not fingerprinted
+func asset(name string) string {
+	assets.Lock()
+	defer assets.Unlock()
+	if hashed, ok := assets.paths[strings.TrimPrefix(name, "/")]; ok {
+		return strings.TrimSuffix(name, strings.TrimPrefix(name, "/")) + hashed
+	}
+	return name
+}
+
+// unfingerprint removes the content hash from the asset path
+func unfingerprint(path string) string {
+	ext := filepath.Ext(path)
+	return fingerprintRe.ReplaceAllString(strings.TrimSuffix(path, ext), "") + ext
+}
@@ -0,0 +1,108 @@
+package z
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// BUNDLES is the file in ZSDIR mapping bundle names to the lists of files
+// they are made of, e.g. "all.css: [reset.css, style.css]"
+const BUNDLES = "bundles.yaml"
+
+// bundles returns the bundles from the bundles file, if any. Bundle names and
+// files are relative to the source directory.
+func bundles() (map[string][]string, error) {
+	b, err := ioutil.ReadFile(zsPath(BUNDLES))
+	if os.IsNotExist(err) {
+		return map[string][]string{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	list := map[string][]string{}
+	if err := yaml.Unmarshal(b, &list); err != nil {
+		return nil, errors.New(BUNDLES + ": " + err.Error())
+	}
+	for name := range list {
+		if clean := path.Clean(strings.TrimPrefix(name, "/")); clean != name {
+			list[clean] = list[name]
+			delete(list, name)
+		}
+	}
+	return list, nil
+}
+
+// bundleInputs returns the set of files (relative to the source directory)
+// that belong to the bundles. They are not built on their own.
+func bundleInputs(list map[string][]string) map[string]bool {
+	inputs := map[string]bool{}
+	for _, files := range list {
+		for _, file := range files {
+			inputs[path.Clean(strings.TrimPrefix(file, "/"))] = true
+		}
+	}
+	return inputs
+}
+
+// buildBundles concatenates the files of every bundle in order and writes
+// the bundle into the output directory. CSS bundles are minified if "minify"
+// variable is 1, bundles are fingerprinted like other CSS and JavaScript
+// files, so templates refer to them with the asset function.
+func buildBundles(list map[string][]string, vars Vars) error {
+	names := []string{}
+	for name := range list {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		buf := &bytes.Buffer{}
+		for _, file := range list[name] {
+			src := sourcePath(filepath.FromSlash(strings.TrimPrefix(file, "/")))
+			if src == "" {
+				return errors.New(name + ": " + file + " not found")
+			}
+			b, err := ioutil.ReadFile(src)
+			if err != nil {
+				return err
+			}
+			buf.Write(b)
+			if path.Ext(name) == ".js" {
+				buf.WriteString(";")
+			}
+			buf.WriteString("\n")
+		}
+		if vars["minify"] == "1" && path.Ext(name) == ".css" {
+			min := &bytes.Buffer{}
+			if err := minifier.Minify("text/css", min, buf); err != nil {
+				return err
+			}
+			buf = min
+		}
+		out := filepath.FromSlash(name)
+		if err := os.MkdirAll(filepath.Join(pubDir, filepath.Dir(out)), 0755); err != nil {
+			return err
+		}
+		if fingerprinted(out, vars) {
+			if err := fingerprint(out, buf.Bytes()); err != nil {
+				return err
+			}
+			continue
+		}
+		if ok, err := claimOutput(out, BUNDLES, vars); err != nil {
+			return err
+		} else if !ok {
+			continue
+		}
+		if err := ioutil.WriteFile(filepath.Join(pubDir, out), buf.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package z
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBundles(t *testing.T) {
+	defer chdirTemp(t)()
+	defer func() { assets.paths = map[string]string{} }()
+
+	os.Mkdir(ZSDIR, 0755)
+	os.Mkdir(PUBDIR, 0755)
+	ioutil.WriteFile(filepath.Join(ZSDIR, BUNDLES), []byte("css/all.css: [a.css, b.css]\nall.js: [a.js, b.js]\n"), 0644)
+	ioutil.WriteFile("a.css", []byte("a { color: red; }"), 0644)
+	ioutil.WriteFile("b.css", []byte("b { color: blue; }"), 0644)
+	ioutil.WriteFile("a.js", []byte("var a = 1"), 0644)
+	ioutil.WriteFile("b.js", []byte("var b = 2"), 0644)
+
+	rebuild([]string{"a.css", "b.css", "a.js", "b.js"}, Vars{}, nil)
+	for path, expected := range map[string]string{
+		"css/all.css": "a { color: red; }\nb { color: blue; }\n",
+		"all.js":      "var a = 1;\nvar b = 2;\n",
+	} {
+		if b, err := ioutil.ReadFile(filepath.Join(PUBDIR, path)); err != nil || string(b) != expected {
+			t.Error(path, string(b), err)
+		}
+	}
+	for _, path := range []string{"a.css", "b.css", "a.js", "b.js"} {
+		if _, err := os.Stat(filepath.Join(PUBDIR, path)); err == nil {
+			t.Error(path)
+		}
+	}
+	if err := cleanStale(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(PUBDIR, "css", "all.css")); err != nil {
+		t.Error(err)
+	}
+
+	ioutil.WriteFile("index.amber", []byte("link[href=asset(\"/css/all.css\")]\n"), 0644)
+	rebuild([]string{"index.amber", "a.css", "b.css"}, Vars{"fingerprint": "1", "minify": "1"}, nil)
+	b, err := ioutil.ReadFile(filepath.Join(PUBDIR, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := strings.TrimPrefix(asset("/css/all.css"), "/")
+	if name == "css/all.css" || !strings.Contains(string(b), name) {
+		t.Fatal(name, string(b))
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(PUBDIR, filepath.FromSlash(name))); err != nil || string(b) != "a{color:red}b{color:blue}" {
+		t.Error(string(b), err)
+	}
+	if err := cleanStale(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(PUBDIR, filepath.FromSlash(name))); err != nil {
+		t.Error(err)
+	}
+
+	ioutil.WriteFile(filepath.Join(ZSDIR, BUNDLES), []byte("all.css: [missing.css]\n"), 0644)
+	list, _ := bundles()
+	if err := buildBundles(list, Vars{}); err == nil || !strings.Contains(err.Error(), "missing.css") {
+		t.Error(err)
+	}
+}
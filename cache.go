@@ -0,0 +1,135 @@
+package z
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// CACHEDIR is the directory in ZSDIR of the source directory keeping the
+// rendered Markdown pages, so that unchanged pages are not rendered again even
+// if their modification time has changed (e.g. after git checkout)
+const CACHEDIR = "cache"
+
+// cacheDir returns the path of the cache directory
+func cacheDir() string {
+	return filepath.Join(srcDir, ZSDIR, CACHEDIR)
+}
+
+// cache keeps the hash of everything pages of a build share: the service files
+// (layouts and partials) and the list of pages. It's computed once per build.
+var cache = struct {
+	sync.Mutex
+	salt string
+}{}
+
+// uncached keeps the pages that read files while rendering: partials
+// relative to the page directory, image sizes, CSV files, git history and
+// variables of other files. They are not cached, as these files are not a
+// part of the cache key.
+var uncached = struct {
+	sync.Mutex
+	pages map[string]bool
+}{pages: map[string]bool{}}
+
+// markUncached keeps the page being rendered out of the cache during the build
+func markUncached(file string) {
+	if file == "" {
+		return
+	}
+	uncached.Lock()
+	uncached.pages[file] = true
+	uncached.Unlock()
+}
+
+// resetCache makes the next build hash the service files and pages again
+func resetCache() {
+	cache.Lock()
+	cache.salt = ""
+	cache.Unlock()
+	uncached.Lock()
+	uncached.pages = map[string]bool{}
+	uncached.Unlock()
+}
+
+// cacheSalt returns the hash of the service files and the pages of the build
+func cacheSalt() string {
+	cache.Lock()
+	defer cache.Unlock()
+	if cache.salt != "" {
+		return cache.salt
+	}
+	h := sha256.New()
+	for _, dir := range zsDirs() {
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			if info.IsDir() {
+				if path == filepath.Join(dir, CACHEDIR) || path == cacheDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if b, err := ioutil.ReadFile(path); err == nil {
+				h.Write([]byte(path + "\x00"))
+				h.Write(b)
+			}
+			return nil
+		})
+	}
+	b, _ := json.Marshal(pages)
+	h.Write(b)
+	cache.salt = hex.EncodeToString(h.Sum(nil))
+	return cache.salt
+}
+
+// cacheKey returns the cache key of the page with the given source and
+// variables
+func cacheKey(src []byte, vars Vars) string {
+	h := sha256.New()
+	h.Write([]byte(cacheSalt()))
+	h.Write(src)
+	names := []string{}
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		h.Write([]byte("\x00" + name + "=" + vars[name]))
+	}
+	assets := fingerprints()
+	sort.Strings(assets)
+	for _, asset := range assets {
+		h.Write([]byte("\x00" + asset))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cached returns the cached output with the given key, if any
+func cached(key string) ([]byte, bool) {
+	b, err := ioutil.ReadFile(filepath.Join(cacheDir(), key))
+	return b, err == nil
+}
+
+// storeCache saves the output with the given key in the cache
+func storeCache(key string, b []byte) error {
+	if err := os.MkdirAll(cacheDir(), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(cacheDir(), key), b, 0644)
+}
+
+// cleanCache removes the cache of the rendered pages
+func cleanCache() error {
+	logInfo("clean:", cacheDir())
+	if *dryRun {
+		return nil
+	}
+	return os.RemoveAll(cacheDir())
+}
@@ -0,0 +1,92 @@
+package z
+
+import (
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	defer chdirTemp(t)()
+
+	os.Mkdir(ZSDIR, 0755)
+	ioutil.WriteFile(filepath.Join(ZSDIR, "layout.amber"), []byte("div #{unescaped(content)}\n"), 0644)
+	ioutil.WriteFile("index.md", []byte("Hello\n"), 0644)
+	out := filepath.Join(PUBDIR, "index.html")
+
+	rebuild([]string{"index.md"}, Vars{}, nil)
+	b, err := ioutil.ReadFile(out)
+	if err != nil || string(b) != "<div><p>Hello</p>\n</div>\n" {
+		t.Fatal(string(b), err)
+	}
+	entries, _ := ioutil.ReadDir(cacheDir())
+	if len(entries) != 1 {
+		t.Fatal(entries)
+	}
+	// Identical rebuild (e.g. after git checkout) copies the cached output
+	ioutil.WriteFile(filepath.Join(cacheDir(), entries[0].Name()), []byte("cached"), 0644)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes("index.md", future, future)
+	rebuild([]string{"index.md"}, Vars{}, nil)
+	if b, _ := ioutil.ReadFile(out); string(b) != "cached" {
+		t.Error(string(b))
+	}
+
+	// Changed layout is a miss
+	ioutil.WriteFile(filepath.Join(ZSDIR, "layout.amber"), []byte("p #{unescaped(content)}\n"), 0644)
+	rebuild([]string{"index.md"}, Vars{}, nil)
+	if b, _ := ioutil.ReadFile(out); string(b) != "<p><p>Hello</p>\n</p>\n" {
+		t.Error(string(b))
+	}
+	if entries, _ := ioutil.ReadDir(cacheDir()); len(entries) != 2 {
+		t.Error(entries)
+	}
+
+	// Pages including files relative to their directory are not cached
+	ioutil.WriteFile(filepath.Join(ZSDIR, "layout.amber"), []byte("div #{include(\"note.html\")}\n"), 0644)
+	ioutil.WriteFile("note.html", []byte("<b>note</b>"), 0644)
+	rebuild([]string{"index.md"}, Vars{}, nil)
+	if b, _ := ioutil.ReadFile(out); string(b) != "<div><b>note</b></div>\n" {
+		t.Error(string(b))
+	}
+	if entries, _ := ioutil.ReadDir(cacheDir()); len(entries) != 2 {
+		t.Error(entries)
+	}
+
+	// Forced build doesn't use the cache
+	ioutil.WriteFile(filepath.Join(ZSDIR, "layout.amber"), []byte("div #{unescaped(content)}\n"), 0644)
+	*force = true
+	rebuild([]string{"index.md"}, Vars{}, nil)
+	*force = false
+	if b, _ := ioutil.ReadFile(out); string(b) != "<div><p>Hello</p>\n</div>\n" {
+		t.Error(string(b))
+	}
+
+	// Pages with images are not cached, as the image sizes may change
+	writePNG := func(width, height int) {
+		f, _ := os.Create("a.png")
+		png.Encode(f, image.NewRGBA(image.Rect(0, 0, width, height)))
+		f.Close()
+	}
+	writePNG(10, 10)
+	ioutil.WriteFile("index.md", []byte("![A](a.png)\n"), 0644)
+	rebuild([]string{"index.md"}, Vars{}, nil)
+	writePNG(40, 20)
+	os.Chtimes("index.md", future, future)
+	rebuild([]string{"index.md"}, Vars{}, nil)
+	if b, _ := ioutil.ReadFile(out); !strings.Contains(string(b), `width="40" height="20"`) {
+		t.Error(string(b))
+	}
+
+	if err := cleanCache(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(cacheDir()); !os.IsNotExist(err) {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,136 @@
+package z
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Links of the rendered Markdown pages
+var linkRe = regexp.MustCompile(`(?:href|src)="([^"]*)"`)
+
+// check validates the site without building it and returns all the problems
+// found: headers that fail to parse or don't match the schema, missing
+// layouts, global variables listed in the "require" variable that are not
+// set, pages sharing the same output and links of Markdown pages to missing
+// outputs.
+func check(vars Vars) []error {
+	problems := []error{}
+	for _, name := range strings.Split(vars["require"], ",") {
+		if name = strings.TrimSpace(name); name != "" && vars[strings.ToLower(name)] == "" {
+			problems = append(problems, fmt.Errorf("global variable %s is not set", name))
+		}
+	}
+
+	globals := pageGlobals(vars)
+	outputs := map[string]string{}
+	links := map[string][]string{}
+	addOutput := func(out, src string) {
+		out = filepath.ToSlash(out)
+		if other, ok := outputs[out]; ok {
+			problems = append(problems, fmt.Errorf("%s: same output %s as %s", src, out, other))
+			return
+		}
+		outputs[out] = src
+	}
+	walkSources(func(file string, info os.FileInfo) error {
+		rel := relPath(file)
+		if !isPage(file) && !hasHeader(file) {
+			addOutput(outputPath(rel, globals), rel)
+			return nil
+		}
+		v, body, err := getVars(file, globals)
+		if err != nil {
+			problems = append(problems, err)
+			return nil
+		}
+		if isDraft(v) || isScheduled(v) {
+			return nil
+		}
+		if s := loadSchema(); s != nil && isPage(file) {
+			header, _ := pageHeader(file)
+			errs, unknown := s.validate(header, v, globals)
+			for _, err := range errs {
+				problems = append(problems, fmt.Errorf("%s: %v", rel, err))
+			}
+			for _, name := range unknown {
+				logWarn(fmt.Sprintf("%s: unknown variable %s", rel, name))
+			}
+		}
+		addOutput(urlOutput(v["url"]), rel)
+		list, err := aliases(v)
+		if err != nil {
+			problems = append(problems, fmt.Errorf("%s: %v", rel, err))
+		}
+		for _, alias := range list {
+			addOutput(alias, rel)
+		}
+		if isMarkdown(file) {
+			if _, err := os.Stat(zsPath(v["layout"])); err != nil && hasLayout(v["layout"]) {
+				problems = append(problems, fmt.Errorf("%s: layout %s not found", rel, v["layout"]))
+			}
+			for _, m := range linkRe.FindAllStringSubmatch(markdown(body, v), -1) {
+				if target, ok := linkTarget(v["url"], m[1]); ok {
+					links[rel] = append(links[rel], target)
+				}
+			}
+		}
+		return nil
+	})
+
+	for _, name := range generated {
+		outputs[name] = name
+	}
+	srcs := []string{}
+	for src := range links {
+		srcs = append(srcs, src)
+	}
+	sort.Strings(srcs)
+	for _, src := range srcs {
+		for _, target := range links[src] {
+			_, ok := outputs[target]
+			if _, dir := outputs[target+"/index.html"]; !ok && !dir && !strings.HasPrefix(target, tagsDir+"/") {
+				problems = append(problems, fmt.Errorf("%s: broken link to %s", src, target))
+			}
+		}
+	}
+	return problems
+}
+
+// hasHeader returns true if the HTML file has a header, i.e. it's a template
+func hasHeader(file string) bool {
+	if filepath.Ext(file) != ".html" {
+		return false
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return false
+	}
+	header, _, err := splitHeader(string(b))
+	return err != nil || len(header) > 0
+}
+
+// linkTarget returns the output path (relative to the output directory) the
+// link of the page with the given url refers to, or false if the link is not
+// local, e.g. an absolute url or an anchor
+func linkTarget(url, link string) (string, bool) {
+	if i := strings.IndexAny(link, "?#"); i != -1 {
+		link = link[:i]
+	}
+	if link == "" || strings.Contains(link, ":") || strings.HasPrefix(link, "//") {
+		return "", false
+	}
+	if !strings.HasPrefix(link, "/") {
+		link = path.Dir("/"+url) + "/" + link
+	}
+	target := strings.TrimPrefix(path.Clean(link), "/")
+	if strings.HasSuffix(link, "/") || target == "" {
+		target = strings.TrimPrefix(target+"/index.html", "/")
+	}
+	return target, true
+}
@@ -0,0 +1,53 @@
+package z
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	defer chdirTemp(t)()
+
+	os.Mkdir(ZSDIR, 0755)
+	os.Mkdir("blog", 0755)
+	files := map[string]string{
+		ZSDIR + "/layout.amber": "div #{unescaped(content)}",
+		"index.md":              "[Post](blog/post.html) [Blog](blog/) [Style](/style.css) [Feed](rss.xml) [Top](#top) [Go](https://golang.org)",
+		"style.css":             "p{}",
+		"blog/index.md":         "[Home](../index.html) [Missing](missing.html)",
+		"blog/post.md":          "layout: post.amber\n---\nPost",
+		"blog/copy.md":          "url: blog/post.html\n---\nCopy",
+		"blog/bad.md":           "title: [unclosed\n---\nBad",
+		"blog/draft.md":         "draft: true\nurl: blog/post.html\n---\nDraft",
+	}
+	for name, content := range files {
+		ioutil.WriteFile(name, []byte(content), 0644)
+	}
+
+	problems := []string{}
+	for _, err := range check(Vars{"require": "title, URL", "title": "Site"}) {
+		problems = append(problems, err.Error())
+	}
+	sort.Strings(problems)
+	expected := []string{
+		"blog/bad.md:2: failed to parse header",
+		"blog/index.md: broken link to blog/missing.html",
+		"blog/post.md: layout post.amber not found",
+		"blog/post.md: same output blog/post.html as blog/copy.md",
+		"global variable URL is not set",
+	}
+	if len(problems) != len(expected) {
+		t.Fatal(problems)
+	}
+	for i, problem := range problems {
+		if !strings.HasPrefix(problem, expected[i]) {
+			t.Error(problem)
+		}
+	}
+	if _, err := os.Stat(PUBDIR); err == nil {
+		t.Error("check has written the output directory")
+	}
+}
@@ -0,0 +1,8 @@
+// Command z builds static sites, see github.com/cjp/z for the usage
+package main
+
+import "github.com/cjp/z"
+
+func main() {
+	z.Main()
+}
@@ -0,0 +1,62 @@
+package z
+
+import (
+	"bytes"
+	"encoding/csv"
+	"html"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// csvTable renders the CSV file as an HTML table, e.g. #{csv("prices.csv")}.
+// The first row is the table header. The path is relative to the source
+// directory, an optional delimiter can be given for other formats, e.g. "\t"
+// for TSV. Errors are returned as HTML comments.
+func csvTable(name string, delimiter ...string) template.HTML {
+	path := sourcePath(filepath.FromSlash(strings.TrimPrefix(name, "/")))
+	if path == "" {
+		return template.HTML("<!-- csv " + name + ": no such file -->")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return template.HTML("<!-- csv " + name + ": " + err.Error() + " -->")
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	if len(delimiter) > 0 {
+		c, n := utf8.DecodeRuneInString(delimiter[0])
+		if n == 0 || n != len(delimiter[0]) {
+			return template.HTML("<!-- csv " + name + ": invalid delimiter -->")
+		}
+		r.Comma = c
+	}
+	rows, err := r.ReadAll()
+	if err != nil {
+		return template.HTML("<!-- csv " + name + ": " + err.Error() + " -->")
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteString("<table>")
+	for i, row := range rows {
+		if i == 0 {
+			buf.WriteString("<thead><tr>")
+			for _, cell := range row {
+				buf.WriteString("<th>" + html.EscapeString(cell) + "</th>")
+			}
+			buf.WriteString("</tr></thead><tbody>")
+			continue
+		}
+		buf.WriteString("<tr>")
+		for _, cell := range row {
+			buf.WriteString("<td>" + html.EscapeString(cell) + "</td>")
+		}
+		buf.WriteString("</tr>")
+	}
+	if len(rows) > 0 {
+		buf.WriteString("</tbody>")
+	}
+	buf.WriteString("</table>")
+	return template.HTML(buf.String())
+}
@@ -0,0 +1,30 @@
+package z
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestCSVTable(t *testing.T) {
+	defer chdirTemp(t)()
+
+	ioutil.WriteFile("simple.csv", []byte("name,price\napple,1\npear,2\n"), 0644)
+	ioutil.WriteFile("quoted.csv", []byte("name,note\n\"Fish, chips\",\"say \"\"hi\"\" <b>\"\n"), 0644)
+	ioutil.WriteFile("tabs.tsv", []byte("a\tb\n1\t2\n"), 0644)
+	ioutil.WriteFile("bad.csv", []byte("a,b\n1,2,3\n"), 0644)
+
+	for s, expected := range map[string]string{
+		string(csvTable("simple.csv")): "<table><thead><tr><th>name</th><th>price</th></tr></thead><tbody>" +
+			"<tr><td>apple</td><td>1</td></tr><tr><td>pear</td><td>2</td></tr></tbody></table>",
+		string(csvTable("/quoted.csv")): "<table><thead><tr><th>name</th><th>note</th></tr></thead><tbody>" +
+			"<tr><td>Fish, chips</td><td>say &#34;hi&#34; &lt;b&gt;</td></tr></tbody></table>",
+		string(csvTable("tabs.tsv", "\t")): "<table><thead><tr><th>a</th><th>b</th></tr></thead><tbody>" +
+			"<tr><td>1</td><td>2</td></tr></tbody></table>",
+		string(csvTable("bad.csv")):     "<!-- csv bad.csv: record on line 2: wrong number of fields -->",
+		string(csvTable("missing.csv")): "<!-- csv missing.csv: no such file -->",
+	} {
+		if s != expected {
+			t.Error(s)
+		}
+	}
+}
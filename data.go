@@ -0,0 +1,70 @@
+package z
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// DATADIR is the directory in ZSDIR with YAML data files available to the
+// templates as "data", e.g. data.team for .zs/data/team.yaml
+const DATADIR = "data"
+
+// siteData is the content of the data files by their names without the
+// extension. It is loaded at the start of every build.
+var siteData = map[string]interface{}{}
+
+// dataFiles returns the data files, later files override the earlier ones
+// with the same name like in zsPath
+func dataFiles() []string {
+	files := []string{}
+	for _, dir := range zsDirs() {
+		matches, _ := filepath.Glob(filepath.Join(dir, DATADIR, "*"))
+		for _, path := range matches {
+			if ext := filepath.Ext(path); ext == ".yaml" || ext == ".yml" {
+				files = append(files, path)
+			}
+		}
+	}
+	return files
+}
+
+// loadData reads all the data files into siteData
+func loadData() error {
+	siteData = map[string]interface{}{}
+	for _, path := range dataFiles() {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var value interface{}
+		if err := yaml.Unmarshal(b, &value); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		siteData[name] = stringKeys(value)
+	}
+	return nil
+}
+
+// stringKeys converts YAML maps to maps with string keys, so that templates
+// can refer to their values by name
+func stringKeys(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for k, v := range value {
+			m[fmt.Sprint(k)] = stringKeys(v)
+		}
+		return m
+	case []interface{}:
+		for i, v := range value {
+			value[i] = stringKeys(v)
+		}
+		return value
+	}
+	return value
+}
@@ -0,0 +1,39 @@
+package z
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestData(t *testing.T) {
+	defer chdirTemp(t)()
+	defer func() { siteData = map[string]interface{}{} }()
+
+	os.MkdirAll(filepath.Join(ZSDIR, DATADIR), 0755)
+	ioutil.WriteFile(filepath.Join(ZSDIR, DATADIR, "team.yaml"), []byte("- name: Alice\n  role: dev\n- name: Bob\n  role: ops\n"), 0644)
+	ioutil.WriteFile(filepath.Join(ZSDIR, DATADIR, "site.yml"), []byte("owner: Carol\nlinks:\n  home: /\n"), 0644)
+	ioutil.WriteFile(filepath.Join(ZSDIR, DATADIR, "notes.txt"), []byte("ignored"), 0644)
+	if err := loadData(); err != nil {
+		t.Fatal(err)
+	}
+	if len(siteData) != 2 {
+		t.Error(siteData)
+	}
+
+	ioutil.WriteFile("team.amber", []byte("ul\n\teach $m in data.team\n\t\tli #{$m.name} (#{$m.role})\np #{data.site.owner} #{data.site.links.home}\n"), 0644)
+	buf := &bytes.Buffer{}
+	if err := buildAmber("team.amber", buf, Vars{}); err != nil {
+		t.Fatal(err)
+	}
+	if s := buf.String(); s != "<ul>\n\t<li>Alice (dev)</li>\n\t<li>Bob (ops)</li>\n</ul>\n<p>Carol /</p>\n" {
+		t.Error(s)
+	}
+
+	ioutil.WriteFile(filepath.Join(ZSDIR, DATADIR, "bad.yaml"), []byte("a: b: c\n"), 0644)
+	if err := loadData(); err == nil {
+		t.Error("bad data file loaded")
+	}
+}
@@ -0,0 +1,147 @@
+package z
+
+import (
+	"fmt"
+	"mime"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// deployTarget is where the output directory is deployed: an rsync
+// destination like "user@host:/var/www" or an S3 bucket like
+// "s3://bucket/prefix"
+type deployTarget struct {
+	kind   string // "rsync" or "s3"
+	dest   string // rsync destination
+	bucket string
+	prefix string
+}
+
+// parseTarget parses the deploy target given with ZS_DEPLOY_TARGET
+func parseTarget(s string) (deployTarget, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return deployTarget{}, fmt.Errorf("no deploy target, set ZS_DEPLOY_TARGET")
+	}
+	if strings.HasPrefix(s, "s3://") {
+		rest := strings.TrimPrefix(s, "s3://")
+		bucket, prefix := rest, ""
+		if i := strings.Index(rest, "/"); i != -1 {
+			bucket, prefix = rest[:i], strings.Trim(rest[i+1:], "/")
+		}
+		if bucket == "" {
+			return deployTarget{}, fmt.Errorf("no bucket in deploy target %s", s)
+		}
+		return deployTarget{kind: "s3", bucket: bucket, prefix: prefix}, nil
+	}
+	if strings.Contains(s, "://") {
+		return deployTarget{}, fmt.Errorf("unsupported deploy target %s", s)
+	}
+	i := strings.Index(s, ":")
+	if i <= 0 || i == len(s)-1 {
+		return deployTarget{}, fmt.Errorf("deploy target %s is not host:path", s)
+	}
+	return deployTarget{kind: "rsync", dest: s}, nil
+}
+
+// Content types of the common web files, other files are looked up with mime
+var contentTypes = map[string]string{
+	".html":  "text/html; charset=utf-8",
+	".css":   "text/css; charset=utf-8",
+	".js":    "application/javascript; charset=utf-8",
+	".json":  "application/json",
+	".xml":   "application/xml",
+	".txt":   "text/plain; charset=utf-8",
+	".svg":   "image/svg+xml",
+	".png":   "image/png",
+	".jpg":   "image/jpeg",
+	".jpeg":  "image/jpeg",
+	".gif":   "image/gif",
+	".webp":  "image/webp",
+	".ico":   "image/x-icon",
+	".woff":  "font/woff",
+	".woff2": "font/woff2",
+	".pdf":   "application/pdf",
+	".gz":    "application/gzip",
+}
+
+// contentType returns the content type of the file by its extension
+func contentType(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	if t, ok := contentTypes[ext]; ok {
+		return t
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// deployCommands returns the commands deploying the directory to the target.
+// Rsync mirrors the directory, removing the files that are gone, S3 objects
+// are uploaded one by one with their content types.
+func deployCommands(dir string, target deployTarget, dryRun bool) ([][]string, error) {
+	if target.kind == "rsync" {
+		cmd := []string{"rsync", "-az", "--delete", "-e", "ssh"}
+		if dryRun {
+			cmd = append(cmd, "--dry-run", "--itemize-changes")
+		}
+		return [][]string{append(cmd, filepath.Clean(dir)+string(filepath.Separator), target.dest)}, nil
+	}
+	cmds := [][]string{}
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		key := path.Join(target.prefix, filepath.ToSlash(rel))
+		cmds = append(cmds, []string{"aws", "s3", "cp", p, "s3://" + target.bucket + "/" + key,
+			"--content-type", contentType(p)})
+		return nil
+	})
+	return cmds, err
+}
+
+// deploy copies the output directory to the deploy target. With --dry-run
+// rsync only lists the changes and S3 uploads are printed.
+func deploy(target string) error {
+	t, err := parseTarget(target)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(pubDir); err != nil {
+		return err
+	}
+	cmds, err := deployCommands(pubDir, t, *dryRun)
+	if err != nil {
+		return err
+	}
+	// Rsync and the aws command line tool used for S3 are not bundled, check
+	// that they are installed before deploying anything
+	if len(cmds) > 0 && !(*dryRun && t.kind == "s3") {
+		if _, err := exec.LookPath(cmds[0][0]); err != nil {
+			return fmt.Errorf("%s targets require %s: %v", t.kind, cmds[0][0], err)
+		}
+	}
+	for _, args := range cmds {
+		if *dryRun && t.kind == "s3" {
+			logInfo("deploy:", args[3], "->", args[4], args[6])
+			continue
+		}
+		logDebug("deploy:", strings.Join(args, " "))
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s: %v", args[0], err)
+		}
+	}
+	logInfo("deploy:", target)
+	return nil
+}
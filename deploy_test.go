@@ -0,0 +1,91 @@
+package z
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTarget(t *testing.T) {
+	for s, expected := range map[string]deployTarget{
+		"user@host:/var/www":     {kind: "rsync", dest: "user@host:/var/www"},
+		"host:site":              {kind: "rsync", dest: "host:site"},
+		"s3://bucket":            {kind: "s3", bucket: "bucket"},
+		"s3://bucket/blog/site/": {kind: "s3", bucket: "bucket", prefix: "blog/site"},
+	} {
+		if target, err := parseTarget(s); err != nil {
+			t.Error(s, err)
+		} else if target != expected {
+			t.Error(s, target)
+		}
+	}
+	for _, s := range []string{"", "/var/www", "host:", ":/var/www", "s3://", "ftp://host/www"} {
+		if _, err := parseTarget(s); err == nil {
+			t.Error(s, "accepted")
+		}
+	}
+}
+
+func TestContentType(t *testing.T) {
+	for name, expected := range map[string]string{
+		"index.html":           "text/html; charset=utf-8",
+		"css/style.a1b2c3.css": "text/css; charset=utf-8",
+		"app.js":               "application/javascript; charset=utf-8",
+		"img/Photo.JPG":        "image/jpeg",
+		"logo.svg":             "image/svg+xml",
+		"fonts/a.woff2":        "font/woff2",
+		"sitemap.xml":          "application/xml",
+		"app.js.gz":            "application/gzip",
+		"LICENSE":              "application/octet-stream",
+	} {
+		if s := contentType(name); s != expected {
+			t.Error(name, s)
+		}
+	}
+}
+
+func TestDeployCommands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Mkdir(filepath.Join(dir, "css"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte("<p>Hello</p>"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "css", "style.css"), []byte("p{}"), 0644)
+
+	cmds, err := deployCommands(dir, deployTarget{kind: "rsync", dest: "host:/www"}, true)
+	if expected := [][]string{{"rsync", "-az", "--delete", "-e", "ssh", "--dry-run", "--itemize-changes",
+		dir + string(filepath.Separator), "host:/www"}}; err != nil || !reflect.DeepEqual(cmds, expected) {
+		t.Error(cmds, err)
+	}
+	cmds, err = deployCommands(dir, deployTarget{kind: "s3", bucket: "b", prefix: "site"}, false)
+	expected := [][]string{
+		{"aws", "s3", "cp", filepath.Join(dir, "css", "style.css"), "s3://b/site/css/style.css", "--content-type", "text/css; charset=utf-8"},
+		{"aws", "s3", "cp", filepath.Join(dir, "index.html"), "s3://b/site/index.html", "--content-type", "text/html; charset=utf-8"},
+	}
+	if err != nil || !reflect.DeepEqual(cmds, expected) {
+		t.Error(cmds, err)
+	}
+}
+
+func TestDeployRequiresTool(t *testing.T) {
+	defer chdirTemp(t)()
+	os.MkdirAll(PUBDIR, 0755)
+	ioutil.WriteFile(filepath.Join(PUBDIR, "index.html"), []byte("hi"), 0644)
+
+	path := os.Getenv("PATH")
+	defer os.Setenv("PATH", path)
+	os.Setenv("PATH", "")
+	if err := deploy("s3://bucket"); err == nil || !strings.HasPrefix(err.Error(), "s3 targets require aws:") {
+		t.Error(err)
+	}
+	*dryRun = true
+	defer func() { *dryRun = false }()
+	if err := deploy("s3://bucket"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,75 @@
+package z
+
+// emojis maps the common emoji shortcodes (without the colons) to the emoji
+var emojis = map[string]string{
+	"+1": "👍", "-1": "👎", "thumbsup": "👍", "thumbsdown": "👎",
+	"smile": "😄", "smiley": "😃", "grin": "😁", "grinning": "😀", "laughing": "😆",
+	"joy": "😂", "wink": "😉", "blush": "😊", "innocent": "😇", "heart_eyes": "😍",
+	"kissing_heart": "😘", "yum": "😋", "stuck_out_tongue": "😛", "sunglasses": "😎",
+	"smirk": "😏", "neutral_face": "😐", "expressionless": "😑", "unamused": "😒",
+	"sweat_smile": "😅", "sweat": "😓", "pensive": "😔", "confused": "😕",
+	"disappointed": "😞", "worried": "😟", "angry": "😠", "rage": "😡", "cry": "😢",
+	"sob": "😭", "scream": "😱", "fearful": "😨", "astonished": "😲", "flushed": "😳",
+	"sleeping": "😴", "dizzy_face": "😵", "mask": "😷", "thinking": "🤔", "upside_down_face": "🙃",
+	"slightly_smiling_face": "🙂", "roll_eyes": "🙄", "nerd_face": "🤓", "hugs": "🤗",
+	"wave": "👋", "clap": "👏", "pray": "🙏", "raised_hands": "🙌", "ok_hand": "👌",
+	"point_up": "☝️", "point_down": "👇", "point_left": "👈", "point_right": "👉",
+	"fist": "✊", "muscle": "💪", "v": "✌️", "eyes": "👀", "handshake": "🤝",
+	"heart": "❤️", "broken_heart": "💔", "sparkling_heart": "💖", "yellow_heart": "💛",
+	"green_heart": "💚", "blue_heart": "💙", "purple_heart": "💜", "100": "💯",
+	"fire": "🔥", "sparkles": "✨", "star": "⭐", "star2": "🌟", "zap": "⚡", "boom": "💥",
+	"tada": "🎉", "confetti_ball": "🎊", "gift": "🎁", "balloon": "🎈", "trophy": "🏆",
+	"medal_sports": "🏅", "rocket": "🚀", "airplane": "✈️", "car": "🚗", "bike": "🚲",
+	"ship": "🚢", "sunny": "☀️", "cloud": "☁️", "umbrella": "☔", "snowflake": "❄️",
+	"rainbow": "🌈", "earth_africa": "🌍", "earth_americas": "🌎", "globe_with_meridians": "🌐",
+	"moon": "🌙", "coffee": "☕", "tea": "🍵", "beer": "🍺", "beers": "🍻", "wine_glass": "🍷",
+	"pizza": "🍕", "hamburger": "🍔", "cake": "🍰", "apple": "🍎", "cookie": "🍪",
+	"dog": "🐶", "cat": "🐱", "mouse": "🐭", "rabbit": "🐰", "fox_face": "🦊", "bear": "🐻",
+	"panda_face": "🐼", "penguin": "🐧", "bird": "🐦", "turtle": "🐢", "snake": "🐍",
+	"whale": "🐳", "octopus": "🐙", "bug": "🐛", "bee": "🐝", "unicorn": "🦄",
+	"seedling": "🌱", "evergreen_tree": "🌲", "deciduous_tree": "🌳", "cactus": "🌵",
+	"four_leaf_clover": "🍀", "rose": "🌹", "sunflower": "🌻", "tulip": "🌷",
+	"warning": "⚠️", "no_entry": "⛔", "x": "❌", "white_check_mark": "✅",
+	"heavy_check_mark": "✔️", "question": "❓", "exclamation": "❗", "bulb": "💡",
+	"memo": "📝", "pencil2": "✏️", "book": "📖", "books": "📚", "bookmark": "🔖",
+	"link": "🔗", "paperclip": "📎", "pushpin": "📌", "calendar": "📆", "date": "📅",
+	"clock": "🕐", "hourglass": "⌛", "alarm_clock": "⏰", "lock": "🔒", "unlock": "🔓",
+	"key": "🔑", "hammer": "🔨", "wrench": "🔧", "gear": "⚙️", "package": "📦",
+	"computer": "💻", "keyboard": "⌨️", "iphone": "📱", "email": "📧", "envelope": "✉️",
+	"mailbox": "📫", "bell": "🔔", "mag": "🔍", "chart_with_upwards_trend": "📈",
+	"chart_with_downwards_trend": "📉", "bar_chart": "📊", "moneybag": "💰", "dollar": "💵",
+	"construction": "🚧", "rotating_light": "🚨", "checkered_flag": "🏁", "triangular_flag_on_post": "🚩",
+	"house": "🏠", "office": "🏢", "school": "🏫", "art": "🎨", "musical_note": "🎵",
+	"notes": "🎶", "headphones": "🎧", "camera": "📷", "video_camera": "📹", "movie_camera": "🎥",
+	"soccer": "⚽", "basketball": "🏀", "video_game": "🎮", "dart": "🎯", "game_die": "🎲",
+	"skull": "💀", "ghost": "👻", "alien": "👽", "robot": "🤖", "poop": "💩",
+	"zzz": "💤", "speech_balloon": "💬", "thought_balloon": "💭", "information_source": "ℹ️",
+	"arrow_right": "➡️", "arrow_left": "⬅️", "arrow_up": "⬆️", "arrow_down": "⬇️",
+	"recycle": "♻️", "new": "🆕", "free": "🆓", "up": "🆙", "cool": "🆒", "ok": "🆗", "sos": "🆘",
+}
+
+// isEmojiName returns true if the byte can be a part of an emoji shortcode
+func isEmojiName(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_' || c == '+' || c == '-'
+}
+
+// expandEmoji replaces the known :name: shortcodes in the Markdown text with
+// their emoji. Code blocks, code spans and escaped colons are left as is, so
+// are the unknown shortcodes.
+func expandEmoji(s string) string {
+	return scanMarkdown(s, func(s string, i int) (string, int) {
+		if s[i] != ':' {
+			return "", 0
+		}
+		end := i + 1
+		for end < len(s) && isEmojiName(s[end]) {
+			end++
+		}
+		if end < len(s) && s[end] == ':' {
+			if emoji, ok := emojis[s[i+1:end]]; ok {
+				return emoji, end + 1 - i
+			}
+		}
+		return "", 0
+	})
+}
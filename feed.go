@@ -0,0 +1,219 @@
+package z
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Date layouts accepted in the "date" header variable
+var dateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02 15:04",
+	"2006-01-02 15:04:05",
+	"02-01-2006",
+	time.RFC3339,
+}
+
+// parseDate parses a date header value using any of the known layouts
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, strings.TrimSpace(s)); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown date format: %s", s)
+}
+
+// dateFormat formats a date header value using the given layout, e.g.
+// #{dateFormat(date, "Jan 2, 2006")} in templates
+func dateFormat(s, layout string) (string, error) {
+	t, err := parseDate(s)
+	if err != nil {
+		return "", err
+	}
+	return t.Format(layout), nil
+}
+
+// absURL makes page url absolute using the base site url (if any)
+func absURL(base, url string) string {
+	if base == "" {
+		return url
+	}
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(url, "/")
+}
+
+type rss struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Description string `xml:"description"`
+	PubDate     string `xml:"pubDate"`
+	GUID        string `xml:"guid"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	Link    atomLink    `xml:"link"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Author  *atomAuthor `xml:"author,omitempty"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Title   string   `xml:"title"`
+	Link    atomLink `xml:"link"`
+	ID      string   `xml:"id"`
+	Updated string   `xml:"updated"`
+	Summary string   `xml:"summary,omitempty"`
+}
+
+// datedPage is a Markdown page with a parsed "date" variable
+type datedPage struct {
+	Vars Vars
+	Date time.Time
+}
+
+// datedPages returns the pages that have a valid date, newest first
+func datedPages(pages []Vars) []datedPage {
+	dated := []datedPage{}
+	for _, v := range pages {
+		if v["date"] == "" {
+			continue
+		}
+		if date, err := parseDate(v["date"]); err != nil {
+			logError(v["file"] + ": " + err.Error())
+		} else {
+			dated = append(dated, datedPage{v, date})
+		}
+	}
+	sort.SliceStable(dated, func(i, j int) bool {
+		return dated[i].Date.After(dated[j].Date)
+	})
+	return dated
+}
+
+// buildFeeds writes the feeds of the format given by the "feed_format"
+// variable: "rss" (default), "atom" or "both"
+func buildFeeds(vars Vars, pages []Vars) error {
+	switch vars["feed_format"] {
+	case "", "rss":
+		return buildFeed(vars, pages)
+	case "atom":
+		return buildAtom(vars, pages)
+	case "both":
+		if err := buildFeed(vars, pages); err != nil {
+			return err
+		}
+		return buildAtom(vars, pages)
+	default:
+		return fmt.Errorf("unknown feed format: %s", vars["feed_format"])
+	}
+}
+
+// buildFeed writes RSS 2.0 feed of all dated pages into the output directory.
+// Channel metadata is taken from the global title, url and description.
+// If there are no dated pages - no feed is written.
+func buildFeed(vars Vars, pages []Vars) error {
+	dated := datedPages(pages)
+	if len(dated) == 0 {
+		return nil
+	}
+	feed := rss{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       vars["title"],
+			Link:        vars["url"],
+			Description: vars["description"],
+		},
+	}
+	for _, p := range dated {
+		link := absURL(vars["url"], p.Vars["url"])
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       p.Vars["title"],
+			Link:        link,
+			Description: p.Vars["description"],
+			PubDate:     p.Date.Format(time.RFC1123Z),
+			GUID:        link,
+		})
+	}
+
+	f, err := os.Create(filepath.Join(pubDir, "rss.xml"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(f)
+	enc.Indent("", "\t")
+	return enc.Encode(feed)
+}
+
+// buildAtom writes Atom feed of all dated pages into the output directory.
+// Like the RSS feed, it's only written if there are dated pages. Entry ids are
+// the absolute page urls.
+func buildAtom(vars Vars, pages []Vars) error {
+	dated := datedPages(pages)
+	if len(dated) == 0 {
+		return nil
+	}
+	feed := atomFeed{
+		Title:   vars["title"],
+		Link:    atomLink{Href: vars["url"]},
+		ID:      vars["url"],
+		Updated: dated[0].Date.Format(time.RFC3339),
+	}
+	if vars["author"] != "" {
+		feed.Author = &atomAuthor{Name: vars["author"]}
+	}
+	for _, p := range dated {
+		link := absURL(vars["url"], p.Vars["url"])
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:   p.Vars["title"],
+			Link:    atomLink{Href: link},
+			ID:      link,
+			Updated: p.Date.Format(time.RFC3339),
+			Summary: p.Vars["description"],
+		})
+	}
+
+	f, err := os.Create(filepath.Join(pubDir, "atom.xml"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(f)
+	enc.Indent("", "\t")
+	return enc.Encode(feed)
+}
@@ -0,0 +1,103 @@
+package z
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseDate(t *testing.T) {
+	for s, expected := range map[string]time.Time{
+		"2015-08-28":                 time.Date(2015, 8, 28, 0, 0, 0, 0, time.UTC),
+		"2015-08-28 15:04":           time.Date(2015, 8, 28, 15, 4, 0, 0, time.UTC),
+		"2015-08-28 15:04:05":        time.Date(2015, 8, 28, 15, 4, 5, 0, time.UTC),
+		"28-08-2015":                 time.Date(2015, 8, 28, 0, 0, 0, 0, time.UTC),
+		"2015-08-28T15:04:05Z":       time.Date(2015, 8, 28, 15, 4, 5, 0, time.UTC),
+		" 2015-08-28T15:04:05+02:00": time.Date(2015, 8, 28, 13, 4, 5, 0, time.UTC),
+	} {
+		if date, err := parseDate(s); err != nil {
+			t.Error(s, err)
+		} else if !date.Equal(expected) {
+			t.Error(s, date)
+		}
+	}
+	for _, s := range []string{"", "yesterday", "2015-13-01", "28/08/2015"} {
+		if _, err := parseDate(s); err == nil {
+			t.Error(s)
+		}
+	}
+}
+
+func TestDateFormat(t *testing.T) {
+	if s, err := dateFormat("2015-08-28", "Jan 2, 2006"); err != nil || s != "Aug 28, 2015" {
+		t.Error(s, err)
+	}
+	if _, err := dateFormat("not a date", "Jan 2, 2006"); err == nil {
+		t.Error("malformed date formatted")
+	}
+	tmpl, err := amberTemplate(`p #{dateFormat(date, "2 January 2006")}`, Vars{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, templateData(Vars{"date": "2015-08-28 10:00"})); err != nil {
+		t.Error(err)
+	} else if buf.String() != "<p>28 August 2015</p>\n" {
+		t.Error(buf.String())
+	}
+}
+
+func TestAtom(t *testing.T) {
+	defer chdirTemp(t)()
+
+	os.Mkdir(PUBDIR, 0755)
+	pages := []Vars{
+		{"title": "First", "url": "posts/first.html", "date": "2015-08-28"},
+		{"title": "Second", "url": "posts/second.html", "date": "2015-09-01", "description": "More"},
+		{"title": "About", "url": "about.html"},
+	}
+	vars := Vars{"title": "Blog", "url": "http://example.com/", "author": "Me", "feed_format": "both"}
+	if err := buildFeeds(vars, pages); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(PUBDIR, "rss.xml")); err != nil {
+		t.Error(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(PUBDIR, "atom.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+	<title>Blog</title>
+	<link href="http://example.com/"></link>
+	<id>http://example.com/</id>
+	<updated>2015-09-01T00:00:00Z</updated>
+	<author>
+		<name>Me</name>
+	</author>
+	<entry>
+		<title>Second</title>
+		<link href="http://example.com/posts/second.html"></link>
+		<id>http://example.com/posts/second.html</id>
+		<updated>2015-09-01T00:00:00Z</updated>
+		<summary>More</summary>
+	</entry>
+	<entry>
+		<title>First</title>
+		<link href="http://example.com/posts/first.html"></link>
+		<id>http://example.com/posts/first.html</id>
+		<updated>2015-08-28T00:00:00Z</updated>
+	</entry>
+</feed>`
+	if string(b) != expected {
+		t.Error(string(b))
+	}
+
+	if err := buildFeeds(Vars{"feed_format": "json"}, pages); err == nil {
+		t.Error("unknown feed format accepted")
+	}
+}
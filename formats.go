@@ -0,0 +1,67 @@
+package z
+
+import (
+	"html"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Output format of the plain text copies of the pages
+const TXT = "txt"
+
+var (
+	anchorRe     = regexp.MustCompile(`(?s)<a\s[^>]*href="([^"]*)"[^>]*>(.*?)</a>`)
+	blankLinesRe = regexp.MustCompile(`\n{3,}`)
+)
+
+// hasFormat returns true if the page is built into the given format, listed in
+// the comma-separated "formats" page variable. Every page is built into HTML.
+func hasFormat(vars Vars, format string) bool {
+	for _, f := range strings.Split(vars["formats"], ",") {
+		if strings.EqualFold(strings.TrimSpace(f), format) {
+			return true
+		}
+	}
+	return false
+}
+
+// txtOutput returns the path of the plain text copy of the HTML output
+func txtOutput(out string) string {
+	return renameExt(out, ".html", "."+TXT)
+}
+
+// plainText converts rendered HTML into plain text: list items are prefixed
+// with dashes, links are followed by their urls, other tags are stripped
+func plainText(s string) string {
+	s = anchorRe.ReplaceAllStringFunc(s, func(a string) string {
+		m := anchorRe.FindStringSubmatch(a)
+		if text := tagRe.ReplaceAllString(m[2], ""); text != m[1] {
+			return m[2] + " (" + m[1] + ")"
+		}
+		return m[2]
+	})
+	s = strings.Replace(s, "<li>", "- ", -1)
+	s = html.UnescapeString(tagRe.ReplaceAllString(s, ""))
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	s = blankLinesRe.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(s) + "\n"
+}
+
+// buildPlainText writes the plain text copy of the Markdown page body into
+// the output (relative to the output directory)
+func buildPlainText(out, body string, vars Vars) error {
+	if ok, err := claimOutput(out, vars["file"], vars); !ok {
+		return err
+	}
+	path := filepath.Join(pubDir, out)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(plainText(markdown(body, vars))), 0644)
+}
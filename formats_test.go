@@ -0,0 +1,58 @@
+package z
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPlainText(t *testing.T) {
+	s := plainText(markdown("# Hello &amp; bye\n\nSome *text* with [a link](http://example.com) and <http://example.com>.\n\n* one\n* two\n\n```\na < b\n```\n", Vars{}))
+	expected := "Hello & bye\n\nSome text with a link (http://example.com) and http://example.com.\n\n- one\n- two\n\na < b\n"
+	if s != expected {
+		t.Errorf("%q", s)
+	}
+}
+
+func TestTxtFormat(t *testing.T) {
+	defer chdirTemp(t)()
+
+	os.Mkdir(ZSDIR, 0755)
+	os.Mkdir("blog", 0755)
+	ioutil.WriteFile(filepath.Join(ZSDIR, "layout.amber"), []byte("div #{unescaped(content)}\n"), 0644)
+	ioutil.WriteFile("blog/post.md", []byte("formats: html, txt\n---\n# Hello\n\nSome <b>bold</b> *text*.\n"), 0644)
+	ioutil.WriteFile("blog/toml.md", []byte("+++\nformats = [\"html\", \"txt\"]\n+++\nTOML\n"), 0644)
+	ioutil.WriteFile("blog/list.md", []byte("formats: [html, txt]\n---\nList\n"), 0644)
+	ioutil.WriteFile("blog/other.md", []byte("# Other\n"), 0644)
+
+	if errs := rebuild([]string{"blog/post.md", "blog/toml.md", "blog/list.md", "blog/other.md"}, Vars{}, nil); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if _, err := os.Stat(filepath.Join(PUBDIR, "blog", "post.html")); err != nil {
+		t.Error(err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(PUBDIR, "blog", "post.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(b); s != "Hello\n\nSome bold text.\n" || strings.ContainsAny(s, "<>") {
+		t.Errorf("%q", s)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(PUBDIR, "blog", "toml.txt")); err != nil || string(b) != "TOML\n" {
+		t.Error(string(b), err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(PUBDIR, "blog", "list.txt")); err != nil || string(b) != "List\n" {
+		t.Error(string(b), err)
+	}
+	if _, err := os.Stat(filepath.Join(PUBDIR, "blog", "other.txt")); !os.IsNotExist(err) {
+		t.Error(err)
+	}
+	if err := cleanStale(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(PUBDIR, "blog", "post.txt")); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,54 @@
+package z
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// gitFields are the fields of the last commit of a file available with the
+// gitlog template function, in the order of gitFormat
+var gitFields = []string{"date", "author", "email", "hash"}
+
+// gitFormat is the git log format of the gitFields
+const gitFormat = "--format=%cI%x00%an%x00%ae%x00%h"
+
+// gitlogs caches the last commits of the files during a build
+var gitlogs = struct {
+	sync.Mutex
+	commits map[string]map[string]string
+}{commits: map[string]map[string]string{}}
+
+// resetGitLogs forgets the commits read by the previous build
+func resetGitLogs() {
+	gitlogs.Lock()
+	defer gitlogs.Unlock()
+	gitlogs.commits = map[string]map[string]string{}
+}
+
+// gitlog returns the field of the last commit of the file, e.g.
+// #{gitlog(file, "date")} for the page being rendered. Fields are "date"
+// (RFC 3339), "author", "email" and "hash". If git is not available, the file
+// is not in a git repository or is not committed yet, the field is empty.
+func gitlog(path, field string) string {
+	gitlogs.Lock()
+	commit, ok := gitlogs.commits[path]
+	gitlogs.Unlock()
+	if !ok {
+		commit = map[string]string{}
+		cmd := exec.Command("git", "log", "-1", gitFormat, "--", filepath.Base(path))
+		cmd.Dir = filepath.Dir(path)
+		if b, err := cmd.Output(); err == nil {
+			if values := strings.Split(strings.TrimSpace(string(b)), "\x00"); len(values) == len(gitFields) {
+				for i, name := range gitFields {
+					commit[name] = values[i]
+				}
+			}
+		}
+		gitlogs.Lock()
+		gitlogs.commits[path] = commit
+		gitlogs.Unlock()
+	}
+	return commit[field]
+}
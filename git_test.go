@@ -0,0 +1,70 @@
+package z
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestGitLog(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+	dir, err := ioutil.TempDir("", "zs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer resetGitLogs()
+
+	if s := gitlog(filepath.Join(dir, "post.md"), "author"); s != "" {
+		t.Error(s)
+	}
+	resetGitLogs()
+
+	os.Mkdir(filepath.Join(dir, "blog"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "blog", "post.md"), []byte("Hello\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "blog", "draft.md"), []byte("Draft\n"), 0644)
+	git := func(args ...string) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=Jane Doe", "GIT_AUTHOR_EMAIL=jane@example.com",
+			"GIT_COMMITTER_NAME=Jane Doe", "GIT_COMMITTER_EMAIL=jane@example.com",
+			"GIT_COMMITTER_DATE=2015-08-28T12:00:00Z", "GIT_AUTHOR_DATE=2015-08-28T12:00:00Z")
+		if b, err := cmd.CombinedOutput(); err != nil {
+			t.Fatal(string(b), err)
+		}
+	}
+	git("init", "-q")
+	git("add", "blog/post.md")
+	git("commit", "-q", "-m", "Add post")
+
+	path := filepath.Join(dir, "blog", "post.md")
+	for field, expected := range map[string]string{
+		"author": "Jane Doe",
+		"email":  "jane@example.com",
+		"date":   "2015-08-28T12:00:00+00:00",
+		"nope":   "",
+	} {
+		if s := gitlog(path, field); s != expected {
+			t.Error(field, s)
+		}
+	}
+	if s := gitlog(path, "hash"); len(s) < 7 {
+		t.Error(s)
+	}
+	if s := gitlog(filepath.Join(dir, "blog", "draft.md"), "author"); s != "" {
+		t.Error(s)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "page.amber"), []byte("p Edited by #{gitlog(\""+filepath.ToSlash(path)+"\", \"author\")}\n"), 0644)
+	buf := &bytes.Buffer{}
+	if err := buildAmber(filepath.Join(dir, "page.amber"), buf, Vars{}); err != nil {
+		t.Fatal(err)
+	} else if buf.String() != "<p>Edited by Jane Doe</p>\n" {
+		t.Error(buf.String())
+	}
+}
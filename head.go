@@ -0,0 +1,70 @@
+package z
+
+import (
+	"bytes"
+	"html"
+	"strings"
+
+	parsehtml "github.com/tdewolff/parse/html"
+)
+
+// autoHead adds a <title>, a description meta tag and a canonical link to the
+// <head> of the page if they are missing, using the "title", "description"
+// and "link" variables. Pages without a <head> are not changed. The page is
+// tokenized with the HTML lexer of the vendored minifier, so that the tags
+// are inserted at their byte offsets and the rest of the page stays as is.
+func autoHead(b []byte, vars Vars) []byte {
+	l := parsehtml.NewLexer(bytes.NewReader(b))
+	offset, insert := 0, -1
+	inHead, tag, name, rel := false, "", "", ""
+	hasTitle, hasDescription, hasCanonical := false, false, false
+	for done := false; !done; {
+		tt, data := l.Next()
+		switch tt {
+		case parsehtml.ErrorToken:
+			done = true
+		case parsehtml.StartTagToken:
+			tag, name, rel = string(l.Text()), "", ""
+			if inHead && tag == "body" {
+				done = true
+			} else if inHead && tag == "title" {
+				hasTitle = true
+			}
+		case parsehtml.AttributeToken:
+			value := strings.ToLower(strings.Trim(string(l.AttrVal()), `"'`))
+			switch string(l.Text()) {
+			case "name":
+				name = value
+			case "rel":
+				rel = value
+			}
+		case parsehtml.StartTagCloseToken, parsehtml.StartTagVoidToken:
+			if tag == "head" && !inHead {
+				inHead, insert = true, offset+len(data)
+			} else if inHead && tag == "meta" && name == "description" {
+				hasDescription = true
+			} else if inHead && tag == "link" && rel == "canonical" {
+				hasCanonical = true
+			}
+		case parsehtml.EndTagToken:
+			if inHead && string(l.Text()) == "head" {
+				insert, done = offset, true
+			}
+		}
+		offset += len(data)
+	}
+	tags := ""
+	if !hasTitle && vars["title"] != "" {
+		tags += "<title>" + html.EscapeString(vars["title"]) + "</title>"
+	}
+	if !hasDescription && vars["description"] != "" {
+		tags += `<meta name="description" content="` + html.EscapeString(vars["description"]) + `">`
+	}
+	if !hasCanonical && vars["link"] != "" {
+		tags += `<link rel="canonical" href="` + html.EscapeString(vars["link"]) + `">`
+	}
+	if insert == -1 || tags == "" {
+		return b
+	}
+	return append(append(append([]byte{}, b[:insert]...), tags...), b[insert:]...)
+}
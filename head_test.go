@@ -0,0 +1,42 @@
+package z
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAutoHead(t *testing.T) {
+	vars := Vars{"title": "Tom & Jerry", "description": "A \"cartoon\"", "link": "/blog/post.html"}
+	for s, expected := range map[string]string{
+		// Missing tags are added before </head>
+		"<html><head><meta charset=\"utf-8\"></head><body><title>x</title></body></html>": "<html><head><meta charset=\"utf-8\">" +
+			"<title>Tom &amp; Jerry</title><meta name=\"description\" content=\"A &#34;cartoon&#34;\"><link rel=\"canonical\" href=\"/blog/post.html\">" +
+			"</head><body><title>x</title></body></html>",
+		// Existing tags are kept
+		"<HTML><HEAD><Title>Own</Title><META NAME=Description content=own><link rel='canonical' href=/own></HEAD><body></body></HTML>": "<HTML><HEAD><Title>Own</Title><META NAME=Description content=own><link rel='canonical' href=/own></HEAD><body></body></HTML>",
+		// Head without the end tag
+		"<head><title>Own</title>\n<body><p>Hi</p>": "<head><meta name=\"description\" content=\"A &#34;cartoon&#34;\"><link rel=\"canonical\" href=\"/blog/post.html\"><title>Own</title>\n<body><p>Hi</p>",
+		// Fragments without a head are not changed
+		"<p>Hello</p>": "<p>Hello</p>",
+		"<html><body><script>var s = '<head>';</script></body></html>": "<html><body><script>var s = '<head>';</script></body></html>",
+	} {
+		b := []byte(s)
+		if out := string(autoHead(b, vars)); out != expected {
+			t.Error(out)
+		}
+		if string(b) != s {
+			t.Error("source changed", string(b))
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	page := "<html><head></head><body></body></html>"
+	if err := writeHTML(buf, bytes.NewBufferString(page), Vars{"title": "Hi"}); err != nil || buf.String() != page {
+		t.Error(buf.String(), err)
+	}
+	buf.Reset()
+	if err := writeHTML(buf, bytes.NewBufferString(page), Vars{"title": "Hi", "auto_head": "1"}); err != nil ||
+		buf.String() != "<html><head><title>Hi</title></head><body></body></html>" {
+		t.Error(buf.String(), err)
+	}
+}
@@ -0,0 +1,146 @@
+package z
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// IGNORE is the file in the source directory listing gitignore-style
+// patterns of the files that are not published
+const IGNORE = ".zsignore"
+
+// ignoreRule is one pattern of the ignore file
+type ignoreRule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// ignores caches the rules of the ignore file during a build
+var ignores = struct {
+	sync.Mutex
+	loaded bool
+	rules  []ignoreRule
+}{}
+
+// resetIgnores clears the ignore rules cache, so that the ignore file is read
+// again
+func resetIgnores() {
+	ignores.Lock()
+	defer ignores.Unlock()
+	ignores.loaded = false
+	ignores.rules = nil
+}
+
+// ignoreRules returns the rules of the ignore file in the source directory
+func ignoreRules() []ignoreRule {
+	ignores.Lock()
+	defer ignores.Unlock()
+	if !ignores.loaded {
+		b, _ := ioutil.ReadFile(filepath.Join(srcDir, IGNORE))
+		ignores.rules = parseIgnore(string(b))
+		ignores.loaded = true
+	}
+	return ignores.rules
+}
+
+// parseIgnore parses gitignore-style patterns, one per line. Empty lines and
+// lines starting with # are skipped. Patterns without a slash match the name
+// at any level, other patterns are relative to the source directory. Trailing
+// slash only matches directories, leading ! re-includes the matching files.
+func parseIgnore(s string) []ignoreRule {
+	rules := []ignoreRule{}
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := ignoreRule{}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		prefix := "^(.*/)?"
+		if strings.Contains(line, "/") {
+			prefix = "^"
+			line = strings.TrimPrefix(line, "/")
+		}
+		rule.re = regexp.MustCompile(prefix + globRegexp(line) + "$")
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// globRegexp converts the glob pattern into a regular expression. "**"
+// matches any number of directories, "*" and "?" don't match slashes.
+func globRegexp(glob string) string {
+	var re strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			re.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			re.WriteString("[^/]*")
+		case glob[i] == '?':
+			re.WriteString("[^/]")
+		default:
+			re.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	return re.String()
+}
+
+// ignored returns true if the file or directory (relative to the source
+// directory, with slashes) is ignored by the rules. Files in the ignored
+// directories are ignored too. The last matching rule wins.
+func ignored(rel string, dir bool, rules []ignoreRule) bool {
+	parts := strings.Split(rel, "/")
+	for i := range parts {
+		isDir := dir || i < len(parts)-1
+		match := false
+		name := strings.Join(parts[:i+1], "/")
+		for _, rule := range rules {
+			if (!rule.dirOnly || isDir) && rule.re.MatchString(name) {
+				match = !rule.negate
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// watchIgnored returns true if changes of the source file should not trigger
+// a rebuild in watch mode. Patterns are given in "watch_ignore" variable
+// (ZS_WATCH_IGNORE or --watch-ignore flag) separated by commas, the same way
+// as in the ignore file.
+func watchIgnored(path string, vars Vars) bool {
+	if vars["watch_ignore"] == "" {
+		return false
+	}
+	rules := parseIgnore(strings.Replace(vars["watch_ignore"], ",", "\n", -1))
+	return ignored(filepath.ToSlash(relPath(path)), false, rules)
+}
+
+// isIgnored returns true if the source file is ignored by the ignore file
+func isIgnored(path string) bool {
+	rules := ignoreRules()
+	if len(rules) == 0 {
+		return false
+	}
+	info, err := os.Stat(path)
+	return ignored(filepath.ToSlash(relPath(path)), err == nil && info.IsDir(), rules)
+}
@@ -0,0 +1,127 @@
+package z
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIgnored(t *testing.T) {
+	rules := parseIgnore("# comment\n\nnode_modules/\n/README.md\n*.sh\n!deploy.sh\ndocs/**/*.txt\n")
+	for rel, expected := range map[string]bool{
+		"node_modules/a/index.js": true,
+		"lib/node_modules/a.js":   true,
+		"README.md":               true,
+		"blog/README.md":          false,
+		"build.sh":                true,
+		"scripts/build.sh":        true,
+		"deploy.sh":               false,
+		"scripts/deploy.sh":       false,
+		"docs/a.txt":              true,
+		"docs/a/b/c.txt":          true,
+		"docs/a.md":               false,
+		"index.md":                false,
+	} {
+		if ignored(rel, false, rules) != expected {
+			t.Error(rel, expected)
+		}
+	}
+	// Directory patterns don't match files
+	if ignored("node_modules", false, parseIgnore("node_modules/")) {
+		t.Error("file ignored by directory pattern")
+	}
+	if !ignored("node_modules", true, parseIgnore("node_modules/")) {
+		t.Error("directory not ignored")
+	}
+}
+
+func TestIgnoreFile(t *testing.T) {
+	defer chdirTemp(t)()
+	defer resetIgnores()
+
+	os.MkdirAll("node_modules/lib", 0755)
+	os.MkdirAll("scripts", 0755)
+	files := map[string]string{
+		IGNORE:                  "node_modules/\nREADME.md\nscripts/*\n!scripts/keep.sh\n",
+		"node_modules/lib/a.js": "a",
+		"README.md":             "readme",
+		"index.md":              "index",
+		"scripts/build.sh":      "build",
+		"scripts/keep.sh":       "keep",
+	}
+	for name, content := range files {
+		ioutil.WriteFile(name, []byte(content), 0644)
+	}
+	resetIgnores()
+	dirs, paths := walk(".", time.Unix(0, 0))
+	if len(dirs) != 2 || dirs[0] != "." || dirs[1] != "scripts" {
+		t.Error(dirs)
+	}
+	if len(paths) != 2 || paths[0] != "index.md" || paths[1] != filepath.Join("scripts", "keep.sh") {
+		t.Error(paths)
+	}
+}
+
+func TestWatchIgnore(t *testing.T) {
+	defer chdirTemp(t)()
+
+	os.Mkdir("data", 0755)
+	ioutil.WriteFile("index.md", []byte("Hello\n"), 0644)
+	ioutil.WriteFile("about.md", []byte("About\n"), 0644)
+	ioutil.WriteFile(IGNORE, []byte("about.md\n"), 0644)
+	defer resetIgnores()
+	vars := Vars{"watch_ignore": "*~, data/*.json", "layout": "none"}
+	for path, expected := range map[string]bool{
+		"index.md":        false,
+		"index.md~":       true,
+		"blog/post.md~":   true,
+		"data/big.json":   true,
+		"blog/data.json":  false,
+		"data/small.yaml": false,
+	} {
+		if watchIgnored(path, vars) != expected {
+			t.Error(path, expected)
+		}
+	}
+
+	builds := make(chan bool, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	site := &Site{SrcDir: ".", PubDir: PUBDIR, Vars: vars}
+	go site.Watch(ctx, func() { builds <- true })
+	wait := func() bool {
+		select {
+		case <-builds:
+			return true
+		case <-time.After(watchDelay + time.Second):
+			return false
+		}
+	}
+	if !wait() {
+		t.Fatal("no initial build")
+	}
+	ioutil.WriteFile("data/big.json", []byte("{}"), 0644)
+	ioutil.WriteFile("index.md~", []byte("Backup\n"), 0644)
+	if wait() {
+		t.Error("ignored change rebuilt")
+	}
+	ioutil.WriteFile("index.md", []byte("Changed\n"), 0644)
+	if !wait() {
+		t.Error("change not rebuilt")
+	}
+
+	// Changed ignore file rebuilds the site without the ignored files
+	ioutil.WriteFile(IGNORE, []byte("index.md\n"), 0644)
+	if !wait() {
+		t.Fatal("ignore file change not rebuilt")
+	}
+	if _, err := os.Stat(filepath.Join(PUBDIR, "index.html")); err == nil {
+		t.Error("ignored output kept")
+	}
+	if _, err := os.Stat(filepath.Join(PUBDIR, "about.html")); err != nil {
+		t.Error("no longer ignored page not built")
+	}
+}
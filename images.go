@@ -0,0 +1,214 @@
+package z
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Widths of the resized images, unless given in "image_widths" variable
+var defaultImageWidths = []int{400, 800, 1600}
+
+// imageWidths returns the widths of the resized images from "image_widths"
+// variable, e.g. "400,800,1600", in ascending order
+func imageWidths(vars Vars) ([]int, error) {
+	if vars["image_widths"] == "" {
+		return defaultImageWidths, nil
+	}
+	widths := []int{}
+	for _, s := range strings.Split(vars["image_widths"], ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("image widths: invalid width %q", s)
+		}
+		widths = append(widths, n)
+	}
+	sort.Ints(widths)
+	return widths, nil
+}
+
+// resizable returns true if the source image (relative to the source
+// directory) matches the comma-separated glob patterns of "images" variable
+func resizable(rel string, vars Vars) bool {
+	ext := strings.ToLower(path.Ext(rel))
+	if vars["images"] == "" || (ext != ".jpg" && ext != ".jpeg" && ext != ".png") {
+		return false
+	}
+	for _, glob := range strings.Split(vars["images"], ",") {
+		glob = strings.TrimPrefix(strings.TrimSpace(glob), "/")
+		if glob != "" && regexp.MustCompile("^"+globRegexp(glob)+"$").MatchString(filepath.ToSlash(rel)) {
+			return true
+		}
+	}
+	return false
+}
+
+// imageVariant returns the name of the image resized to the given width, e.g.
+// img/photo-400w.jpg for img/photo.jpg
+func imageVariant(name string, width int) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "-" + strconv.Itoa(width) + "w" + ext
+}
+
+// imageVariants returns the widths of the resized copies of the source image.
+// Images are never enlarged, so only the widths smaller than the image width
+// are returned, along with the image width.
+func imageVariants(src string, vars Vars) (widths []int, width int, err error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	config, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, 0, err
+	}
+	all, err := imageWidths(vars)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, w := range all {
+		if w < config.Width {
+			widths = append(widths, w)
+		}
+	}
+	return widths, config.Width, nil
+}
+
+// resizeImages writes resized copies of the source images matching "images"
+// variable into the output directory. Copies newer than their source are
+// kept as is.
+func resizeImages(vars Vars) error {
+	if vars["images"] == "" {
+		return nil
+	}
+	return walkSources(func(src string, info os.FileInfo) error {
+		rel := relPath(src)
+		if !resizable(rel, vars) {
+			return nil
+		}
+		widths, _, err := imageVariants(src, vars)
+		if err != nil {
+			return fmt.Errorf("%s: %v", src, err)
+		}
+		var img image.Image
+		for _, width := range widths {
+			out := filepath.Join(pubDir, imageVariant(rel, width))
+			if o, err := os.Stat(out); err == nil && o.ModTime().After(info.ModTime()) {
+				continue
+			}
+			if img == nil {
+				if img, err = decodeImage(src); err != nil {
+					return fmt.Errorf("%s: %v", src, err)
+				}
+			}
+			logDebug("resize:", src, "->", out)
+			if err := writeImage(out, resize(img, width)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// decodeImage reads the image file
+func decodeImage(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// writeImage writes the image in the format given by the file extension
+func writeImage(path string, img image.Image) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png":
+		err = png.Encode(f, img)
+	case ".jpg", ".jpeg":
+		err = jpeg.Encode(f, img, &jpeg.Options{Quality: 85})
+	default:
+		err = errors.New("unsupported image format")
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// resize scales the image down to the given width keeping the aspect ratio.
+// Every output pixel is the average of the source pixels it covers, which is
+// all a downscale needs, so golang.org/x/image is not a dependency.
+func resize(img image.Image, width int) image.Image {
+	b := img.Bounds()
+	height := b.Dy() * width / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := b.Min.Y+y*b.Dy()/height, b.Min.Y+(y+1)*b.Dy()/height
+		if y1 == y0 {
+			y1++
+		}
+		for x := 0; x < width; x++ {
+			x0, x1 := b.Min.X+x*b.Dx()/width, b.Min.X+(x+1)*b.Dx()/width
+			if x1 == x0 {
+				x1++
+			}
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := color.NRGBA64Model.Convert(img.At(sx, sy)).(color.NRGBA64)
+					r, g, bl, a = r+uint64(c.R), g+uint64(c.G), bl+uint64(c.B), a+uint64(c.A)
+					n++
+				}
+			}
+			dst.Set(x, y, color.NRGBA64{uint16(r / n), uint16(g / n), uint16(bl / n), uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+// srcset returns the srcset attribute value of the image with the given path
+// relative to the source directory, e.g. #{srcset("/img/photo.jpg")} gives
+// "/img/photo-400w.jpg 400w, /img/photo.jpg 1200w" for an image 1200 pixels
+// wide. Images that are not resized only list themselves.
+func srcset(name string, vars Vars) string {
+	rel := filepath.FromSlash(strings.TrimPrefix(name, "/"))
+	src := sourcePath(rel)
+	if src == "" {
+		return ""
+	}
+	widths, width, err := imageVariants(src, vars)
+	if err != nil {
+		return ""
+	}
+	set := []string{}
+	if resizable(rel, vars) {
+		for _, w := range widths {
+			set = append(set, imageVariant(name, w)+" "+strconv.Itoa(w)+"w")
+		}
+	}
+	set = append(set, name+" "+strconv.Itoa(width)+"w")
+	return strings.Join(set, ", ")
+}
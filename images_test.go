@@ -0,0 +1,70 @@
+package z
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestResizeImages(t *testing.T) {
+	defer chdirTemp(t)()
+
+	os.Mkdir("img", 0755)
+	img := image.NewRGBA(image.Rect(0, 0, 1000, 500))
+	for x := 0; x < 500; x++ {
+		for y := 0; y < 500; y++ {
+			img.Set(x, y, color.White)
+		}
+	}
+	f, _ := os.Create(filepath.Join("img", "a.png"))
+	png.Encode(f, img)
+	f.Close()
+	ioutil.WriteFile("logo.png", []byte("not resized"), 0644)
+
+	vars := Vars{"images": "img/*", "image_widths": "1600, 400,800"}
+	if err := resizeImages(vars); err != nil {
+		t.Fatal(err)
+	}
+	for name, width := range map[string]int{"a-400w.png": 400, "a-800w.png": 800} {
+		resized, err := decodeImage(filepath.Join(PUBDIR, "img", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := resized.Bounds(); b.Dx() != width || b.Dy() != width/2 {
+			t.Error(name, b)
+		}
+		if c := color.NRGBAModel.Convert(resized.At(0, 0)).(color.NRGBA); c != (color.NRGBA{255, 255, 255, 255}) {
+			t.Error(name, c)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(PUBDIR, "img", "a-1600w.png")); err == nil {
+		t.Error("image enlarged")
+	}
+
+	// Up to date copies are not written again
+	out := filepath.Join(PUBDIR, "img", "a-400w.png")
+	ioutil.WriteFile(out, []byte("kept"), 0644)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(out, future, future)
+	if err := resizeImages(vars); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(out); string(b) != "kept" {
+		t.Error("image resized again")
+	}
+
+	if s := srcset("/img/a.png", vars); s != "/img/a-400w.png 400w, /img/a-800w.png 800w, /img/a.png 1000w" {
+		t.Error(s)
+	}
+	if s := srcset("img/a.png", Vars{}); s != "img/a.png 1000w" {
+		t.Error(s)
+	}
+	if s := srcset("missing.png", vars); s != "" {
+		t.Error(s)
+	}
+}
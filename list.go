@@ -0,0 +1,58 @@
+package z
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+)
+
+// listedPage is a page as printed by the list command
+type listedPage struct {
+	File   string `json:"file"`
+	URL    string `json:"url"`
+	Title  string `json:"title"`
+	Layout string `json:"layout"`
+	Draft  bool   `json:"draft"`
+}
+
+// listPages prints every source page with its url, title, layout and draft
+// status, sorted by the source path. Pages are printed as a table, or as a
+// JSON array if asJSON is true.
+func listPages(w io.Writer, vars Vars, asJSON bool) error {
+	list := []listedPage{}
+	err := walkSources(func(path string, info os.FileInfo) error {
+		if !isPage(path) {
+			return nil
+		}
+		v, _, err := getVars(path, vars)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		list = append(list, listedPage{
+			File:   filepath.ToSlash(relPath(path)),
+			URL:    filepath.ToSlash(v["url"]),
+			Title:  v["title"],
+			Layout: v["layout"],
+			Draft:  v["draft"] == "true",
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		return enc.Encode(list)
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tURL\tTITLE\tLAYOUT\tDRAFT")
+	for _, p := range list {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%v\n", p.File, p.URL, p.Title, p.Layout, p.Draft)
+	}
+	return tw.Flush()
+}
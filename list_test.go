@@ -0,0 +1,70 @@
+package z
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestListPages(t *testing.T) {
+	defer chdirTemp(t)()
+
+	os.Mkdir("posts", 0755)
+	files := map[string]string{
+		"index.amber":  "p Index",
+		"posts/b.md":   "title: B\ndraft: true\n---\nB",
+		"posts/a.md":   "title: A\nurl: a.html\nlayout: post.amber\n---\nA",
+		"styles.gcss":  "body\n  margin: 0",
+		".hidden.md":   "Hidden",
+		"content.html": "<p>Raw</p>",
+	}
+	for name, content := range files {
+		ioutil.WriteFile(name, []byte(content), 0644)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := listPages(buf, Vars{}, false); err != nil {
+		t.Fatal(err)
+	}
+	expected := `FILE         URL           TITLE  LAYOUT       DRAFT
+index.amber  index.html    Index  layout.html  false
+posts/a.md   a.html        A      post.amber   false
+posts/b.md   posts/b.html  B      layout.html  true
+`
+	if buf.String() != expected {
+		t.Error(buf.String())
+	}
+
+	buf.Reset()
+	if err := listPages(buf, Vars{}, true); err != nil {
+		t.Fatal(err)
+	}
+	expected = `[
+	{
+		"file": "index.amber",
+		"url": "index.html",
+		"title": "Index",
+		"layout": "layout.html",
+		"draft": false
+	},
+	{
+		"file": "posts/a.md",
+		"url": "a.html",
+		"title": "A",
+		"layout": "post.amber",
+		"draft": false
+	},
+	{
+		"file": "posts/b.md",
+		"url": "posts/b.html",
+		"title": "B",
+		"layout": "layout.html",
+		"draft": true
+	}
+]
+`
+	if buf.String() != expected {
+		t.Error(buf.String())
+	}
+}
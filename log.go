@@ -0,0 +1,67 @@
+package z
+
+import (
+	"log"
+	"strings"
+)
+
+// Log levels, messages below the current level are not printed
+const (
+	LogDebug = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+var logLevel = LogInfo
+
+var logLevels = map[string]int{
+	"debug": LogDebug,
+	"info":  LogInfo,
+	"warn":  LogWarn,
+	"error": LogError,
+}
+
+// setLogLevel sets the log level from the "log" variable, -v and --quiet
+// flags take precedence
+func setLogLevel(vars Vars) {
+	logLevel = LogInfo
+	if level, ok := logLevels[strings.ToLower(vars["log"])]; ok {
+		logLevel = level
+	} else if vars["log"] != "" {
+		logWarn("unknown log level", vars["log"])
+	}
+	if *verbose {
+		logLevel = LogDebug
+	}
+	if *quiet {
+		logLevel = LogWarn
+	}
+}
+
+// logAt prints the message if the level is enabled. Warnings and errors are
+// prefixed with their level.
+func logAt(level int, v []interface{}) {
+	if level < logLevel {
+		return
+	}
+	switch level {
+	case LogWarn:
+		v = append([]interface{}{"WARN:"}, v...)
+	case LogError:
+		v = append([]interface{}{"ERROR:"}, v...)
+	}
+	log.Println(v...)
+}
+
+// logDebug prints details like skipped files and hook commands
+func logDebug(v ...interface{}) { logAt(LogDebug, v) }
+
+// logInfo prints the progress of the commands
+func logInfo(v ...interface{}) { logAt(LogInfo, v) }
+
+// logWarn prints problems that don't stop the command
+func logWarn(v ...interface{}) { logAt(LogWarn, v) }
+
+// logError prints the errors
+func logError(v ...interface{}) { logAt(LogError, v) }
@@ -0,0 +1,49 @@
+package z
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestLogLevel(t *testing.T) {
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	log.SetFlags(0)
+	defer log.SetOutput(os.Stderr)
+	defer log.SetFlags(log.LstdFlags)
+	defer setLogLevel(Vars{})
+
+	logAll := func() string {
+		buf.Reset()
+		logDebug("build:", "a.md")
+		logInfo("clean:", ".pub")
+		logWarn("slow")
+		logError("failed")
+		return buf.String()
+	}
+
+	setLogLevel(Vars{})
+	if s := logAll(); s != "clean: .pub\nWARN: slow\nERROR: failed\n" {
+		t.Error(s)
+	}
+	setLogLevel(Vars{"log": "error"})
+	if s := logAll(); s != "ERROR: failed\n" {
+		t.Error(s)
+	}
+
+	*verbose = true
+	setLogLevel(Vars{"log": "error"})
+	*verbose = false
+	if s := logAll(); s != "build: a.md\nclean: .pub\nWARN: slow\nERROR: failed\n" {
+		t.Error(s)
+	}
+
+	*quiet = true
+	setLogLevel(Vars{"log": "debug"})
+	*quiet = false
+	if s := logAll(); s != "WARN: slow\nERROR: failed\n" {
+		t.Error(s)
+	}
+}
@@ -0,0 +1,58 @@
+package z
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// manifestEntry describes one file of the generated site. Title and
+// description are null for the files that are not pages.
+type manifestEntry struct {
+	File        string  `json:"file"`
+	URL         string  `json:"url"`
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+	Modified    string  `json:"mtime"`
+}
+
+// buildManifest writes manifest.json listing all the files built from the
+// sources into the output directory. It's only written if ZS_MANIFEST is set
+// to 1. Page metadata is taken from page variables, other files are listed
+// with their paths only.
+func buildManifest(vars Vars) error {
+	if vars["manifest"] != "1" {
+		return nil
+	}
+	globals := pageGlobals(vars)
+	manifest := []manifestEntry{}
+	err := walkSources(func(path string, info os.FileInfo) error {
+		entry := manifestEntry{
+			File:     filepath.ToSlash(relPath(path)),
+			URL:      asset(filepath.ToSlash(outputPath(relPath(path), globals))),
+			Modified: info.ModTime().Format(time.RFC3339),
+		}
+		if isPage(path) {
+			v, _, err := getVars(path, globals)
+			if err != nil || isDraft(v) || isScheduled(v) {
+				return nil
+			}
+			title, description := v["title"], v["description"]
+			entry.URL = filepath.ToSlash(v["url"])
+			entry.Title, entry.Description = &title, &description
+		}
+		manifest = append(manifest, entry)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(pubDir, "manifest.json"), b, 0644)
+}
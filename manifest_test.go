@@ -0,0 +1,60 @@
+package z
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	defer chdirTemp(t)()
+
+	os.Mkdir(PUBDIR, 0755)
+	os.Mkdir("img", 0755)
+	files := map[string]string{
+		"a.md":        "title: A\ndescription: About A\n---\nA",
+		"b.md":        "draft: true\n---\nB",
+		"img/x.png":   "",
+		"index.amber": "p Index",
+		"styles.gcss": "body\n  margin: 0",
+	}
+	for name, content := range files {
+		ioutil.WriteFile(name, []byte(content), 0644)
+	}
+
+	if err := buildManifest(Vars{}); err != nil {
+		t.Error(err)
+	} else if _, err := os.Stat(filepath.Join(PUBDIR, "manifest.json")); err == nil {
+		t.Error("manifest written without ZS_MANIFEST")
+	}
+
+	if err := buildManifest(Vars{"manifest": "1", "url": "http://example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadFile(filepath.Join(PUBDIR, "manifest.json"))
+	manifest := []map[string]interface{}{}
+	if err := json.Unmarshal(b, &manifest); err != nil {
+		t.Fatal(err, string(b))
+	}
+	expected := []map[string]interface{}{
+		{"file": "a.md", "url": "a.html", "title": "A", "description": "About A"},
+		{"file": "img/x.png", "url": "img/x.png", "title": nil, "description": nil},
+		{"file": "index.amber", "url": "index.html", "title": "Index", "description": ""},
+		{"file": "styles.gcss", "url": "styles.css", "title": nil, "description": nil},
+	}
+	if len(manifest) != len(expected) {
+		t.Fatal(string(b))
+	}
+	for i, entry := range expected {
+		for key, value := range entry {
+			if manifest[i][key] != value {
+				t.Error(key, manifest[i][key], value)
+			}
+		}
+		if manifest[i]["mtime"] == "" {
+			t.Error(manifest[i])
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package z
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"html/template"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/alecthomas/chroma"
+	chromahtml "github.com/alecthomas/chroma/formatters/html"
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/alecthomas/chroma/styles"
+	"github.com/russross/blackfriday"
+)
+
+// Default color scheme of highlighted code blocks
+const defaultHighlightStyle = "github"
+
+// Markdown extensions and HTML flags, same as in blackfriday.MarkdownCommon,
+// plus generated header ids for the table of contents and footnotes
+const (
+	mdExtensions = blackfriday.EXTENSION_NO_INTRA_EMPHASIS |
+		blackfriday.EXTENSION_TABLES |
+		blackfriday.EXTENSION_FENCED_CODE |
+		blackfriday.EXTENSION_AUTOLINK |
+		blackfriday.EXTENSION_STRIKETHROUGH |
+		blackfriday.EXTENSION_SPACE_HEADERS |
+		blackfriday.EXTENSION_HEADER_IDS |
+		blackfriday.EXTENSION_AUTO_HEADER_IDS |
+		blackfriday.EXTENSION_BACKSLASH_LINE_BREAK |
+		blackfriday.EXTENSION_DEFINITION_LISTS |
+		blackfriday.EXTENSION_FOOTNOTES
+	mdHTMLFlags = blackfriday.HTML_USE_XHTML |
+		blackfriday.HTML_FOOTNOTE_RETURN_LINKS |
+		blackfriday.HTML_USE_SMARTYPANTS |
+		blackfriday.HTML_SMARTYPANTS_FRACTIONS |
+		blackfriday.HTML_SMARTYPANTS_DASHES |
+		blackfriday.HTML_SMARTYPANTS_LATEX_DASHES
+)
+
+// Markdown extensions that can be toggled with ZS_MD_* variables, e.g.
+// ZS_MD_FOOTNOTES=0 or ZS_MD_TABLES=0
+var mdToggles = map[string]int{
+	"md_tables":        blackfriday.EXTENSION_TABLES,
+	"md_footnotes":     blackfriday.EXTENSION_FOOTNOTES,
+	"md_autolink":      blackfriday.EXTENSION_AUTOLINK,
+	"md_strikethrough": blackfriday.EXTENSION_STRIKETHROUGH,
+	"md_fenced_code":   blackfriday.EXTENSION_FENCED_CODE,
+	"md_header_ids":    blackfriday.EXTENSION_HEADER_IDS,
+	"md_auto_ids":      blackfriday.EXTENSION_AUTO_HEADER_IDS,
+}
+
+// extensions returns markdown extensions enabled by default, adjusted by the
+// toggles from the given variables
+func extensions(vars Vars) int {
+	ext := mdExtensions
+	for name, flag := range mdToggles {
+		switch vars[name] {
+		case "1", "true":
+			ext |= flag
+		case "0", "false":
+			ext &^= flag
+		}
+	}
+	return ext
+}
+
+// highlighter is a markdown renderer that highlights fenced code blocks if
+// their language is known
+type highlighter struct {
+	blackfriday.Renderer
+	style *chroma.Style
+}
+
+func (h highlighter) BlockCode(out *bytes.Buffer, text []byte, lang string) {
+	if fields := strings.Fields(lang); len(fields) > 0 {
+		if lexer := lexers.Get(strings.TrimPrefix(fields[0], ".")); lexer != nil {
+			buf := &bytes.Buffer{}
+			it, err := chroma.Coalesce(lexer).Tokenise(nil, string(text))
+			if err == nil {
+				err = chromahtml.New().Format(buf, h.style, it)
+			}
+			if err == nil {
+				if out.Len() > 0 {
+					out.WriteByte('\n')
+				}
+				buf.WriteTo(out)
+				return
+			}
+		}
+	}
+	h.Renderer.BlockCode(out, text, lang)
+}
+
+// markdown renders markdown text into html. Fenced code blocks are
+// highlighted using the color scheme from "highlight_style" variable,
+// extensions are toggled with "md_*" variables. Footnote anchors are prefixed
+// with the page url, so that they are unique when pages are embedded into
+// each other. If "math" variable is 1, $...$ and $$...$$ math is kept intact.
+func markdown(s string, vars Vars) string {
+	spans := []string{}
+	if vars["math"] == "1" {
+		s, spans = extractMath(s)
+	}
+	if vars["emoji"] == "1" {
+		s = expandEmoji(s)
+	}
+	name := vars["highlight_style"]
+	if name == "" {
+		name = defaultHighlightStyle
+	}
+	params := blackfriday.HtmlRendererParameters{}
+	if prefix := slugify(strings.TrimSuffix(vars["url"], filepath.Ext(vars["url"]))); prefix != "" {
+		params.FootnoteAnchorPrefix = prefix + "-"
+	}
+	renderer := highlighter{
+		Renderer: blackfriday.HtmlRendererWithParameters(mdHTMLFlags, "", "", params),
+		style:    styles.Get(name),
+	}
+	return restoreMath(string(blackfriday.Markdown([]byte(s), renderer, extensions(vars))), spans)
+}
+
+// listItemRe matches the lines starting a Markdown list item
+var listItemRe = regexp.MustCompile(`^\s*([-+*]|\d+[.)])(\s|$)`)
+
+// scanMarkdown copies the Markdown text, calling replace at every position
+// outside of code blocks (fenced or indented), code spans and backslash
+// escapes, which are copied as is. Replace returns the text to write instead
+// of the n bytes at position i, or n = 0 to copy the byte.
+func scanMarkdown(s string, replace func(s string, i int) (string, int)) string {
+	out := &strings.Builder{}
+	fenced, indented, blank, list := false, false, true, false
+	for i := 0; i < len(s); {
+		if i == 0 || s[i-1] == '\n' {
+			line := s[i:]
+			if n := strings.IndexByte(line, '\n'); n != -1 {
+				line = line[:n+1]
+			}
+			trimmed := strings.TrimSpace(line)
+			fence := strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~")
+			code := strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "\t")
+			switch {
+			case fenced || fence:
+				fenced = fenced != fence
+			case trimmed == "":
+			case code && !list && (blank || indented):
+				// Indented code can't continue a paragraph or a list item
+				indented = true
+			default:
+				indented = false
+				if !code {
+					list = listItemRe.MatchString(line) || list && !blank
+				}
+			}
+			blank = trimmed == ""
+			if fence || fenced || indented {
+				out.WriteString(line)
+				i += len(line)
+				continue
+			}
+		}
+		c := s[i]
+		if c == '\\' && i+1 < len(s) && s[i+1] != '\n' {
+			out.WriteString(s[i : i+2])
+			i += 2
+			continue
+		}
+		if c == '`' {
+			n := len(s[i:]) - len(strings.TrimLeft(s[i:], "`"))
+			if end := strings.Index(s[i+n:], s[i:i+n]); end != -1 {
+				out.WriteString(s[i : i+n+end+n])
+				i += n + end + n
+				continue
+			}
+		}
+		if r, n := replace(s, i); n > 0 {
+			out.WriteString(r)
+			i += n
+			continue
+		}
+		out.WriteByte(c)
+		i++
+	}
+	return out.String()
+}
+
+var (
+	headingRe = regexp.MustCompile(`(?s)<h([1-6]) id="([^"]*)">(.*?)</h[1-6]>`)
+	tagRe     = regexp.MustCompile(`<[^>]*>`)
+)
+
+// toc returns a nested list of links to the headings of the rendered
+// markdown. Only headings up to the given level (h3 for 3) are listed, all of
+// them by default. If there are no headings - nothing is returned.
+func toc(content string, depth ...int) template.HTML {
+	maxLevel := 6
+	if len(depth) > 0 {
+		maxLevel = depth[0]
+	}
+	buf := &bytes.Buffer{}
+	levels := []int{}
+	for _, m := range headingRe.FindAllStringSubmatch(content, -1) {
+		level := int(m[1][0] - '0')
+		if level > maxLevel {
+			continue
+		}
+		for len(levels) > 0 && levels[len(levels)-1] > level {
+			buf.WriteString("</li></ul>")
+			levels = levels[:len(levels)-1]
+		}
+		if len(levels) > 0 && levels[len(levels)-1] == level {
+			buf.WriteString("</li><li>")
+		} else {
+			buf.WriteString("<ul><li>")
+			levels = append(levels, level)
+		}
+		buf.WriteString(`<a href="#` + m[2] + `">` + tagRe.ReplaceAllString(m[3], "") + "</a>")
+	}
+	for range levels {
+		buf.WriteString("</li></ul>")
+	}
+	return template.HTML(buf.String())
+}
+
+// Maximum length of the page description taken from its content
+const maxSummaryLen = 160
+
+// summary returns the first paragraph of markdown text as plain text, which
+// is truncated on a word boundary if it's too long. Headings, code blocks and
+// HTML blocks are skipped.
+func summary(s string) string {
+	for _, block := range strings.Split(strings.Replace(s, "\r\n", "\n", -1), "\n\n") {
+		block = strings.TrimRight(block, " \t\n")
+		trimmed := strings.TrimLeft(block, "\n")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "```") ||
+			strings.HasPrefix(trimmed, "<") || strings.HasPrefix(trimmed, "    ") || strings.HasPrefix(trimmed, "\t") {
+			continue
+		}
+		if lines := strings.Split(trimmed, "\n"); len(lines) == 2 && strings.Trim(lines[1], "=-") == "" {
+			continue
+		}
+		text := string(blackfriday.Markdown([]byte(trimmed), blackfriday.HtmlRenderer(mdHTMLFlags, "", ""), mdExtensions))
+		text = strings.Join(strings.Fields(html.UnescapeString(tagRe.ReplaceAllString(text, ""))), " ")
+		return truncate(text, maxSummaryLen)
+	}
+	return ""
+}
+
+// truncate shortens the text to at most n characters on a word boundary and
+// adds an ellipsis, e.g. #{truncate(description, 80)} in templates
+func truncate(text string, n int) string {
+	r := []rune(text)
+	if len(r) <= n {
+		return text
+	}
+	text = string(r[:n])
+	if i := strings.LastIndex(text, " "); i > 0 {
+		text = text[:i]
+	}
+	return strings.TrimRight(text, ",.;:!?-") + "…"
+}
+
+// markdownify renders the Markdown text with the page variables, a single
+// paragraph is rendered without the <p> tag so that it can be used inline,
+// e.g. #{markdownify(title)} in templates
+func markdownify(text string, vars Vars) template.HTML {
+	s := strings.TrimSpace(markdown(text, vars))
+	if strings.HasPrefix(s, "<p>") && strings.HasSuffix(s, "</p>") && strings.Count(s, "<p>") == 1 {
+		s = s[3 : len(s)-4]
+	}
+	return template.HTML(s)
+}
+
+// moreMarker separates the excerpt of a Markdown page from the rest of it
+const moreMarker = "<!--more-->"
+
+// excerpt returns the rendered part of Markdown body before the "more" marker,
+// image paths are relative to dir like in imageAttrs. Without the marker the
+// page description is used, or the whole content if the description is empty.
+func excerpt(body, content, dir string, vars Vars) string {
+	if i := strings.Index(body, moreMarker); i != -1 {
+		return imageAttrs(markdown(body[:i], vars), dir, vars)
+	}
+	if vars["description"] != "" {
+		return html.EscapeString(vars["description"])
+	}
+	return content
+}
+
+var (
+	imgRe    = regexp.MustCompile(`<img [^>]*>`)
+	imgSrcRe = regexp.MustCompile(` src="([^"]*)"`)
+)
+
+// imageAttrs adds width and height of local images to the img tags of the
+// rendered markdown, as well as loading="lazy" unless "lazy_images" variable
+// is 0. Image paths are relative to dir, or to the source directory if they
+// start with a slash. Remote and unknown images are left as is.
+func imageAttrs(s, dir string, vars Vars) string {
+	return imgRe.ReplaceAllStringFunc(s, func(tag string) string {
+		m := imgSrcRe.FindStringSubmatch(tag)
+		if m == nil || strings.Contains(m[1], "://") || strings.HasPrefix(m[1], "//") ||
+			strings.HasPrefix(m[1], "data:") {
+			return tag
+		}
+		path := filepath.Join(dir, filepath.FromSlash(html.UnescapeString(m[1])))
+		if strings.HasPrefix(m[1], "/") {
+			path = sourcePath(filepath.FromSlash(html.UnescapeString(m[1])))
+		}
+		// Image sizes are not a part of the cache key
+		markUncached(vars["file"])
+		f, err := os.Open(path)
+		if err != nil {
+			return tag
+		}
+		defer f.Close()
+		config, _, err := image.DecodeConfig(f)
+		if err != nil {
+			return tag
+		}
+		attrs := ""
+		if !strings.Contains(tag, " width=") && !strings.Contains(tag, " height=") {
+			attrs += fmt.Sprintf(` width="%d" height="%d"`, config.Width, config.Height)
+		}
+		if vars["lazy_images"] != "0" && !strings.Contains(tag, " loading=") {
+			attrs += ` loading="lazy"`
+		}
+		return strings.Replace(tag, m[0], m[0]+attrs, 1)
+	})
+}
@@ -0,0 +1,196 @@
+package z
+
+import (
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHighlight(t *testing.T) {
+	s := markdown("```go\nfunc main() {}\n```\n", Vars{})
+	if strings.Contains(s, "language-go") || !strings.Contains(s, `<span style=`) {
+		t.Error(s)
+	}
+	s = markdown("```go\nfunc main() {}\n```\n", Vars{"highlight_style": "monokai"})
+	if !strings.Contains(s, "background-color:#272822") {
+		t.Error(s)
+	}
+	if s := markdown("```\nfunc main() {}\n```\n", Vars{}); s != "<pre><code>func main() {}\n</code></pre>\n" {
+		t.Error(s)
+	}
+	if s := markdown("```nosuchlang\nfoo\n```\n", Vars{}); s != "<pre><code class=\"language-nosuchlang\">foo\n</code></pre>\n" {
+		t.Error(s)
+	}
+}
+
+func TestExtensions(t *testing.T) {
+	table := "a | b\n---|---\n1 | 2\n"
+	if s := markdown(table, Vars{}); !strings.Contains(s, "<table>") {
+		t.Error(s)
+	}
+	if s := markdown(table, Vars{"md_tables": "0"}); strings.Contains(s, "<table>") {
+		t.Error(s)
+	}
+	if s := markdown("see http://example.com\n", Vars{"md_autolink": "false"}); strings.Contains(s, "<a ") {
+		t.Error(s)
+	}
+	footnote := "Foo[^1]\n\n[^1]: Bar\n"
+	if s := markdown(footnote, Vars{"md_footnotes": "0"}); strings.Contains(s, "footnote") {
+		t.Error(s)
+	}
+	if s := markdown(footnote, Vars{}); !strings.Contains(s, `class="footnotes"`) {
+		t.Error(s)
+	}
+}
+
+func TestFootnotes(t *testing.T) {
+	s := markdown("Foo[^1] and bar[^note].\n\n[^1]: First\n[^note]: Second\n", Vars{"url": "blog/post.html"})
+	for _, expected := range []string{
+		`<sup class="footnote-ref" id="fnref:blog-post-1"><a rel="footnote" href="#fn:blog-post-1">1</a></sup>`,
+		`<sup class="footnote-ref" id="fnref:blog-post-note"><a rel="footnote" href="#fn:blog-post-note">2</a></sup>`,
+		`<li id="fn:blog-post-1">First`,
+		`<li id="fn:blog-post-note">Second`,
+		`<a class="footnote-return" href="#fnref:blog-post-1"><sup>[return]</sup></a>`,
+	} {
+		if !strings.Contains(s, expected) {
+			t.Error(expected, s)
+		}
+	}
+	if other := markdown("Foo[^1]\n\n[^1]: First\n", Vars{"url": "about.html"}); !strings.Contains(other, `id="fn:about-1"`) {
+		t.Error(other)
+	}
+}
+
+func TestTOC(t *testing.T) {
+	content := markdown("# Intro\n\n## Install *now*\n\n### Linux\n\n## Usage\n\n# End\n", Vars{})
+	expected := `<ul><li><a href="#intro">Intro</a>` +
+		`<ul><li><a href="#install-now">Install now</a>` +
+		`<ul><li><a href="#linux">Linux</a></li></ul>` +
+		`</li><li><a href="#usage">Usage</a></li></ul>` +
+		`</li><li><a href="#end">End</a></li></ul>`
+	if s := toc(content); string(s) != expected {
+		t.Error(s)
+	}
+	if s := toc(content, 1); string(s) != `<ul><li><a href="#intro">Intro</a></li><li><a href="#end">End</a></li></ul>` {
+		t.Error(s)
+	}
+	if s := toc(markdown("No headings\n", Vars{})); s != "" {
+		t.Error(s)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	long := strings.Repeat("word ", 40)
+	for s, expected := range map[string]string{
+		"# Title\n\nFirst *paragraph* with [a link](http://example.com).\n\nSecond.\n": "First paragraph with a link.",
+		"# Only a heading\n":                       "",
+		"Title\n=====\n\n```\ncode\n```\n\nText\n": "Text",
+		long: strings.TrimSpace(strings.Repeat("word ", 32)) + "…",
+	} {
+		if s := summary(s); s != expected {
+			t.Error(s, expected)
+		}
+	}
+	for body, expected := range map[string]string{
+		"# Hello\n\nWorld\n":                "World",
+		"# Hello\n":                         "",
+		"description: Custom\n---\nWorld\n": "Custom",
+		"description: \"\"\n---\nWorld\n":   "",
+	} {
+		if v, _, err := parseVars("test.md", body, Vars{}); err != nil {
+			t.Error(err)
+		} else if v["description"] != expected {
+			t.Error(body, v["description"])
+		}
+	}
+}
+
+func TestImageAttrs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f, err := os.Create(filepath.Join(dir, "a.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	png.Encode(f, image.NewRGBA(image.Rect(0, 0, 30, 20)))
+	f.Close()
+	ioutil.WriteFile(filepath.Join(dir, "broken.png"), []byte("not an image"), 0644)
+
+	s := markdown("![A](a.png) ![Remote](http://example.com/a.png) ![Broken](broken.png) ![Missing](missing.png)\n", Vars{})
+	expected := `<p><img src="a.png" width="30" height="20" loading="lazy" alt="A" /> ` +
+		`<img src="http://example.com/a.png" alt="Remote" /> ` +
+		`<img src="broken.png" alt="Broken" /> ` +
+		`<img src="missing.png" alt="Missing" /></p>` + "\n"
+	if s := imageAttrs(s, dir, Vars{}); s != expected {
+		t.Error(s)
+	}
+	if s := imageAttrs(`<img src="a.png" alt="A" />`, dir, Vars{"lazy_images": "0"}); s != `<img src="a.png" width="30" height="20" alt="A" />` {
+		t.Error(s)
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	body := "Intro *text*.\n\n<!--more-->\n\nThe rest.\n"
+	content := markdown(body, Vars{})
+	if s := excerpt(body, content, ".", Vars{"description": "Intro text."}); s != "<p>Intro <em>text</em>.</p>\n" {
+		t.Error(s)
+	}
+	if !strings.Contains(content, "The rest.") {
+		t.Error(content)
+	}
+	body = "Only <b>one</b> part.\n"
+	if s := excerpt(body, markdown(body, Vars{}), ".", Vars{"description": "Only one & part."}); s != "Only one &amp; part." {
+		t.Error(s)
+	}
+	if s := excerpt(body, "<p>full</p>", ".", Vars{"description": ""}); s != "<p>full</p>" {
+		t.Error(s)
+	}
+}
+
+func TestMath(t *testing.T) {
+	s := "Inline $a_b + c_d$ and _b_ and *x*.\n\n$$\n\\sum_{i=0}^n x_i < y\n$$\n\nCosts $5 and $10. Code `$x_y$` and \\$a_b\\$.\n\n```\n$a_b$\n```\n"
+	expected := `<p>Inline <span class="math">$a_b + c_d$</span> and <em>b</em> and <em>x</em>.</p>` + "\n\n" +
+		`<div class="math">$$` + "\n" + `\sum_{i=0}^n x_i &lt; y` + "\n" + `$$</div>` + "\n\n" +
+		`<p>Costs $5 and $10. Code <code>$x_y$</code> and \$a_b\$.</p>` + "\n\n" +
+		"<pre><code>$a_b$\n</code></pre>\n"
+	if html := markdown(s, Vars{"math": "1"}); html != expected {
+		t.Error(html)
+	}
+	if html := markdown("$a_b_c$ and _d_\n", Vars{"math": "1"}); html != "<p><span class=\"math\">$a_b_c$</span> and <em>d</em></p>\n" {
+		t.Error(html)
+	}
+	if html := markdown("$a _b_ c$\n", Vars{}); html != "<p>$a <em>b</em> c$</p>\n" {
+		t.Error(html)
+	}
+	if html := markdown("Code:\n\n    $a_b$\n", Vars{"math": "1"}); html != "<p>Code:</p>\n\n<pre><code>$a_b$\n</code></pre>\n" {
+		t.Error(html)
+	}
+}
+
+func TestEmoji(t *testing.T) {
+	s := "Launch :rocket: at 12:30:00, :nosuchemoji: and `:rocket:` or \\:rocket:.\n\n```\n:fire:\n```\n"
+	expected := "<p>Launch 🚀 at 12:30:00, :nosuchemoji: and <code>:rocket:</code> or :rocket:.</p>\n\n" +
+		"<pre><code>:fire:\n</code></pre>\n"
+	if html := markdown(s, Vars{"emoji": "1"}); html != expected {
+		t.Error(html)
+	}
+	if html := markdown(":rocket::+1:\n", Vars{"emoji": "1"}); html != "<p>🚀👍</p>\n" {
+		t.Error(html)
+	}
+	if html := markdown(":rocket:\n", Vars{}); html != "<p>:rocket:</p>\n" {
+		t.Error(html)
+	}
+	if html := markdown("Code:\n\n    :fire:\n\tand :fire:\n\nText\n    :fire:\n", Vars{"emoji": "1"}); html != "<p>Code:</p>\n\n<pre><code>:fire:\nand :fire:\n</code></pre>\n\n<p>Text\n    🔥</p>\n" {
+		t.Error(html)
+	}
+	if html := markdown("- item\n\n    more :fire:\n", Vars{"emoji": "1"}); !strings.Contains(html, "more 🔥") {
+		t.Error(html)
+	}
+}
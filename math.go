@@ -0,0 +1,54 @@
+package z
+
+import (
+	"html"
+	"strconv"
+	"strings"
+)
+
+// mathPlaceholder replaces a math span while the Markdown is rendered, so
+// that Markdown emphasis and escapes don't apply to it
+func mathPlaceholder(i int) string {
+	return "ZSMATH" + strconv.Itoa(i) + "ZS"
+}
+
+// extractMath replaces $...$ and $$...$$ math spans in the Markdown text with
+// placeholders and returns the spans. Code blocks, code spans and escaped
+// dollars are left as is. Inline math must not start or end with a space,
+// can't span lines or code spans and can't be followed by a digit, e.g.
+// "costs $5 and $10" is not math.
+func extractMath(s string) (string, []string) {
+	spans := []string{}
+	s = scanMarkdown(s, func(s string, i int) (string, int) {
+		if strings.HasPrefix(s[i:], "$$") {
+			if end := strings.Index(s[i+2:], "$$"); end > 0 {
+				spans = append(spans, s[i:i+2+end+2])
+				return mathPlaceholder(len(spans) - 1), 2 + end + 2
+			}
+		} else if s[i] == '$' {
+			end := strings.IndexAny(s[i+1:], "$`\n")
+			if end > 0 && s[i+1+end] == '$' && s[i+1] != ' ' && s[i+end] != ' ' &&
+				(i+end+2 == len(s) || s[i+end+2] < '0' || s[i+end+2] > '9') {
+				spans = append(spans, s[i:i+1+end+1])
+				return mathPlaceholder(len(spans) - 1), 1 + end + 1
+			}
+		}
+		return "", 0
+	})
+	return s, spans
+}
+
+// restoreMath puts the math spans back into the rendered HTML. Display math
+// that makes a whole paragraph goes into a div, other math into a span, both
+// with "math" class for client-side renderers like KaTeX auto-render.
+func restoreMath(s string, spans []string) string {
+	for i := len(spans) - 1; i >= 0; i-- {
+		math := html.EscapeString(spans[i])
+		p := mathPlaceholder(i)
+		if strings.HasPrefix(spans[i], "$$") {
+			s = strings.Replace(s, "<p>"+p+"</p>", `<div class="math">`+math+"</div>", 1)
+		}
+		s = strings.Replace(s, p, `<span class="math">`+math+"</span>", 1)
+	}
+	return s
+}
@@ -0,0 +1,18 @@
+package z
+
+import "os"
+
+// Extension of the sidecar files holding the variables of raw assets, e.g.
+// photo.jpg.meta.yaml for photo.jpg
+const metaExt = ".meta.yaml"
+
+// metaVars returns the variables of a raw asset from its sidecar file, or
+// false if the asset has no sidecar. Assets get the same default variables as
+// pages (see getVars) and keep their original url.
+func metaVars(path string, globals Vars) (Vars, bool) {
+	if _, err := os.Stat(path + metaExt); err != nil {
+		return nil, false
+	}
+	v, _, err := getVars(path, globals)
+	return v, err == nil
+}
@@ -0,0 +1,68 @@
+package z
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMetaVars(t *testing.T) {
+	defer chdirTemp(t)()
+	defer resetDefaults()
+	defer resetRules()
+
+	os.MkdirAll(ZSDIR, 0755)
+	os.MkdirAll("gallery", 0755)
+	os.MkdirAll(filepath.Join(PUBDIR, "gallery"), 0755)
+	files := map[string]string{
+		"gallery/" + DEFAULTS:          "section: gallery\n",
+		"gallery/sunset.jpg":           "JPEG",
+		"gallery/sunset.jpg" + metaExt: "title: Sunset\ncaption: Over the sea\ndate: 2015-08-28\n",
+		"gallery/draft.jpg":            "JPEG",
+		"gallery/draft.jpg" + metaExt:  "draft: true\n",
+		"gallery/plain.pdf":            "PDF",
+		"post.md":                      "date: 2015-08-29\n---\nPost",
+		filepath.Join(ZSDIR, RULES):    "gallery/*.jpg:\n  type: photo\n  caption: None\n",
+	}
+	for name, content := range files {
+		ioutil.WriteFile(name, []byte(content), 0644)
+	}
+	resetRules()
+	resetDefaults()
+
+	collected := collectPages(Vars{})
+	if len(collected) != 2 {
+		t.Fatal(collected)
+	}
+	v := collected[1]
+	for key, value := range map[string]string{
+		"title":   "Sunset",
+		"caption": "Over the sea",
+		"section": "gallery",
+		"type":    "photo",
+		"url":     "gallery/sunset.jpg",
+		"root":    "../",
+		"link":    "/gallery/sunset.jpg",
+	} {
+		if v[key] != value {
+			t.Error(key, v[key], value)
+		}
+	}
+	if _, ok := metaVars("gallery/plain.pdf", Vars{}); ok {
+		t.Error("asset without sidecar has vars")
+	}
+	if s := meta("gallery/sunset.jpg", "caption"); s != "Over the sea" {
+		t.Error(s)
+	}
+
+	if !hidden("gallery/sunset.jpg" + metaExt) {
+		t.Error("sidecar file is published")
+	}
+	if err := build("gallery/sunset.jpg", nil, Vars{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(PUBDIR, "gallery/sunset.jpg")); err != nil {
+		t.Error(err)
+	}
+}
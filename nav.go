@@ -0,0 +1,87 @@
+package z
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// pageWeight returns the "weight" (or "order") variable of the page, or false
+// if the page has none
+func pageWeight(v Vars) (int, bool) {
+	for _, name := range []string{"weight", "order"} {
+		if n, err := strconv.Atoi(strings.TrimSpace(v[name])); err == nil {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// weightLess returns true if page a goes before page b by their weights,
+// then by their titles. Pages with a weight go before the pages without. The
+// second result is false if neither page has a weight.
+func weightLess(a, b Vars) (bool, bool) {
+	wa, okA := pageWeight(a)
+	wb, okB := pageWeight(b)
+	if !okA && !okB {
+		return false, false
+	}
+	if okA != okB {
+		return okA, true
+	}
+	if wa != wb {
+		return wa < wb, true
+	}
+	return a["title"] < b["title"], true
+}
+
+// pageSection returns the top-level directory of the page, or an empty string
+// for the pages in the root of the source directory
+func pageSection(v Vars) string {
+	if parts := strings.Split(filepath.ToSlash(relPath(v["file"])), "/"); len(parts) > 1 {
+		return parts[0]
+	}
+	return ""
+}
+
+// neighbours returns the previous and the next pages of the same section in
+// the pages order, or false if the page is not listed
+func neighbours(vars Vars) (prev, next Vars, ok bool) {
+	for i, v := range pages {
+		if v["file"] != vars["file"] {
+			continue
+		}
+		section := pageSection(v)
+		for j := i - 1; j >= 0 && prev == nil; j-- {
+			if pageSection(pages[j]) == section {
+				prev = pages[j]
+			}
+		}
+		for j := i + 1; j < len(pages) && next == nil; j++ {
+			if pageSection(pages[j]) == section {
+				next = pages[j]
+			}
+		}
+		return prev, next, true
+	}
+	return nil, nil, false
+}
+
+// menu groups the pages by their sections, keeping the pages order. Sections
+// are ordered by their first page, every one has its "section" name and
+// "pages".
+func menu() []map[string]interface{} {
+	sections := []map[string]interface{}{}
+	index := map[string]int{}
+	for _, v := range pages {
+		section := pageSection(v)
+		i, ok := index[section]
+		if !ok {
+			i = len(sections)
+			index[section] = i
+			sections = append(sections, map[string]interface{}{"section": section, "pages": []Vars{}})
+		}
+		sections[i]["pages"] = append(sections[i]["pages"].([]Vars), v)
+	}
+	return sections
+}
@@ -0,0 +1,57 @@
+package z
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWeight(t *testing.T) {
+	defer chdirTemp(t)()
+	defer func() { pages = nil }()
+
+	os.Mkdir(ZSDIR, 0755)
+	os.Mkdir("docs", 0755)
+	os.Mkdir("blog", 0755)
+	ioutil.WriteFile(filepath.Join(ZSDIR, "layout.amber"), []byte("p #{prev.title}|#{title}|#{next.title}\n"+
+		"each $s in menu\n\tsection #{$s.section}\n\t\teach $p in $s.pages\n\t\t\ti #{$p.title}\n"), 0644)
+	files := map[string]string{
+		"docs/install.md": "title: Install\nweight: 2\n---\n",
+		"docs/intro.md":   "title: Intro\nweight: 1\n---\n",
+		"docs/usage.md":   "title: Usage\norder: 3\n---\n",
+		"docs/faq.md":     "title: FAQ\n---\n",
+		"blog/post.md":    "title: Post\ndate: 2015-08-28\n---\n",
+		"about.md":        "title: About\n---\n",
+	}
+	for name, content := range files {
+		ioutil.WriteFile(name, []byte(content), 0644)
+	}
+
+	pages = collectPages(Vars{})
+	order := ""
+	for _, v := range pages {
+		order += v["title"] + " "
+	}
+	if order != "Intro Install Usage Post About FAQ " {
+		t.Error(order)
+	}
+
+	menu := "<section>docs<i>Intro</i><i>Install</i><i>Usage</i><i>FAQ</i></section>" +
+		"<section>blog<i>Post</i></section><section><i>About</i></section>"
+	for path, expected := range map[string]string{
+		"docs/intro.md":   "<p>|Intro|Install</p>",
+		"docs/install.md": "<p>Intro|Install|Usage</p>",
+		"docs/usage.md":   "<p>Install|Usage|FAQ</p>",
+		"blog/post.md":    "<p>|Post|</p>",
+	} {
+		buf := &bytes.Buffer{}
+		if err := buildMarkdown(path, buf, Vars{}); err != nil {
+			t.Error(err)
+		} else if s := strings.Join(strings.Fields(buf.String()), ""); s != expected+menu {
+			t.Error(path, s)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package z
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// written maps the outputs written during a build (relative to the output
+// directory) to their source files
+var written = struct {
+	sync.Mutex
+	outputs map[string]string
+}{outputs: map[string]string{}}
+
+// resetWritten forgets the outputs written by the previous build
+func resetWritten() {
+	written.Lock()
+	defer written.Unlock()
+	written.outputs = map[string]string{}
+}
+
+// claimOutput records that the source file writes the output (relative to
+// the output directory) and returns false if another source has already
+// claimed it during the build, in which case the output must not be written.
+// The collision is reported as a warning, or returned as an error if
+// ZS_STRICT is set to 1.
+func claimOutput(out, src string, vars Vars) (bool, error) {
+	out = filepath.ToSlash(filepath.Clean(out))
+	written.Lock()
+	other, ok := written.outputs[out]
+	if !ok || other == src {
+		written.outputs[out] = src
+	}
+	written.Unlock()
+	if !ok || other == src {
+		return true, nil
+	}
+	err := fmt.Errorf("%s and %s are both built into %s", other, src, out)
+	if vars["strict"] == "1" {
+		return false, err
+	}
+	logWarn(err.Error())
+	return false, nil
+}
+
+// sourceOutput returns the output of the source file (relative to the output
+// directory), taking the permalink of pages into account
+func sourceOutput(path string, vars Vars) string {
+	if isPage(path) {
+		if v, _, err := getVars(path, vars); err == nil {
+			return pageOutput(path, vars, v)
+		}
+	}
+	return outputPath(relPath(path), vars)
+}
+
+// reserveOutputs claims the outputs of the pages in the order of their paths
+// before the pages are built in parallel, so that colliding outputs are
+// always written by the same source and skipped for the other ones
+func reserveOutputs(paths []string, vars Vars) {
+	sorted := append([]string{}, paths...)
+	sort.Strings(sorted)
+	for _, path := range sorted {
+		if !isPage(path) {
+			continue
+		}
+		v, _, err := getVars(path, vars)
+		if err != nil || isDraft(v) || isScheduled(v) {
+			continue
+		}
+		outs := []string{pageOutput(path, vars, v)}
+		if isMarkdown(path) && hasFormat(v, TXT) {
+			outs = append(outs, txtOutput(outs[0]))
+		}
+		if paths, err := aliases(v); err == nil {
+			outs = append(outs, paths...)
+		}
+		written.Lock()
+		for _, out := range outs {
+			out = filepath.ToSlash(filepath.Clean(out))
+			if _, ok := written.outputs[out]; !ok {
+				written.outputs[out] = path
+			}
+		}
+		written.Unlock()
+	}
+}
+
+// unpublish removes the outputs written for the held back page by the
+// previous builds: the page itself, its aliases and its plain text copy.
+// Outputs written by other sources during the build are kept.
+func unpublish(path string, vars, v Vars) error {
+	out := relPath(path)
+	if isPage(path) {
+		out = pageOutput(path, vars, v)
+	}
+	outs := []string{out}
+	if isMarkdown(path) && hasFormat(v, TXT) {
+		outs = append(outs, txtOutput(out))
+	}
+	if list, err := aliases(v); err == nil {
+		outs = append(outs, list...)
+	}
+	for _, out := range outs {
+		out = filepath.ToSlash(filepath.Clean(out))
+		written.Lock()
+		other, ok := written.outputs[out]
+		written.Unlock()
+		if ok && other != path {
+			continue
+		}
+		for _, name := range []string{out, out + ".gz"} {
+			name = filepath.Join(pubDir, filepath.FromSlash(name))
+			if err := os.Remove(name); err == nil {
+				logInfo("clean:", name)
+			} else if !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package z
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDuplicateOutputs(t *testing.T) {
+	defer chdirTemp(t)()
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+
+	os.Mkdir(ZSDIR, 0755)
+	os.Mkdir(PUBDIR, 0755)
+	os.Mkdir("post", 0755)
+	ioutil.WriteFile(ZSDIR+"/layout.amber", []byte("div #{title}"), 0644)
+	ioutil.WriteFile("post.md", []byte("title: Post\n---\nPost"), 0644)
+	ioutil.WriteFile("post/index.md", []byte("title: Index\n---\nIndex"), 0644)
+	ioutil.WriteFile("other.md", []byte("title: Other\n---\nOther"), 0644)
+
+	vars := Vars{"pretty_urls": "1"}
+	rebuild([]string{"post.md", "post/index.md", "other.md"}, vars, nil)
+	if s := buf.String(); !strings.Contains(s, "WARN:") || !strings.Contains(s, "post.md") ||
+		!strings.Contains(s, "post/index.md") || !strings.Contains(s, "post/index.html") || strings.Contains(s, "other.md") {
+		t.Error(s)
+	}
+
+	// The first source in order wins, whatever the build order is
+	vars["strict"] = "1"
+	*force = true
+	defer func() { *force = false }()
+	for i := 0; i < 5; i++ {
+		errs := rebuild([]string{"post/index.md", "post.md"}, vars, nil)
+		if len(errs) != 1 || !strings.Contains(errs[0].Error(), "post/index.md: post.md and post/index.md") {
+			t.Fatal(errs)
+		}
+		if b, err := ioutil.ReadFile(PUBDIR + "/post/index.html"); err != nil || !strings.Contains(string(b), "Post") {
+			t.Error(string(b), err)
+		}
+	}
+
+	resetWritten()
+	defer resetWritten()
+	if err := build("post.md", nil, vars); err != nil {
+		t.Fatal(err)
+	}
+	if err := build("post/index.md", nil, vars); err == nil || !strings.Contains(err.Error(), "post.md and post/index.md") {
+		t.Error(err)
+	}
+}
+
+func TestDuplicateOutputsSkipped(t *testing.T) {
+	defer chdirTemp(t)()
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+
+	os.Mkdir(ZSDIR, 0755)
+	os.Mkdir(PUBDIR, 0755)
+	os.Mkdir("post", 0755)
+	ioutil.WriteFile(ZSDIR+"/layout.amber", []byte("div #{title}"), 0644)
+	ioutil.WriteFile("post.md", []byte("title: Post\n---\nPost"), 0644)
+	ioutil.WriteFile("post/index.md", []byte("title: Index\n---\nIndex"), 0644)
+
+	// Without ZS_STRICT the first source in order still wins the output
+	vars := Vars{"pretty_urls": "1"}
+	*force = true
+	defer func() { *force = false }()
+	for i := 0; i < 10; i++ {
+		if errs := rebuild([]string{"post/index.md", "post.md"}, vars, nil); len(errs) > 0 {
+			t.Fatal(errs)
+		}
+		if b, err := ioutil.ReadFile(PUBDIR + "/post/index.html"); err != nil || string(b) != "<div>Post</div>\n" {
+			t.Fatal(string(b), err)
+		}
+	}
+}
+
+func TestDuplicatePermalinks(t *testing.T) {
+	defer chdirTemp(t)()
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+
+	os.Mkdir(ZSDIR, 0755)
+	os.Mkdir(PUBDIR, 0755)
+	ioutil.WriteFile(ZSDIR+"/layout.amber", []byte("div #{title}"), 0644)
+	ioutil.WriteFile("a.md", []byte("title: A\npermalink: /same/\n---\nA"), 0644)
+	ioutil.WriteFile("b.md", []byte("title: B\npermalink: /same/\n---\nB"), 0644)
+
+	vars := Vars{}
+	if errs := rebuild([]string{"b.md", "a.md"}, vars, nil); len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if s := buf.String(); !strings.Contains(s, "WARN: a.md and b.md are both built into same/index.html") {
+		t.Error(s)
+	}
+	if b, err := ioutil.ReadFile(PUBDIR + "/same/index.html"); err != nil || string(b) != "<div>A</div>\n" {
+		t.Error(string(b), err)
+	}
+
+	// Up to date sources are still checked for collisions
+	buf.Reset()
+	vars["strict"] = "1"
+	errs := rebuild([]string{"b.md", "a.md"}, vars, nil)
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "a.md and b.md are both built into same/index.html") {
+		t.Error(errs, buf.String())
+	}
+}
@@ -0,0 +1,54 @@
+package z
+
+import (
+	"html"
+	"html/template"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// metas caches the variables of the pages referred to by the meta template
+// function during a build
+var metas = struct {
+	sync.Mutex
+	globals Vars
+	vars    map[string]Vars
+}{vars: map[string]Vars{}}
+
+// resetMetas forgets the variables loaded by the previous build, the pages
+// will be read with the given globals
+func resetMetas(globals Vars) {
+	metas.Lock()
+	defer metas.Unlock()
+	metas.globals = globals
+	metas.vars = map[string]Vars{}
+}
+
+// meta returns the variable of another page, e.g. #{meta("blog/post.md",
+// "title")}. The page path is relative to the source directory. If there is
+// no such page or variable, an HTML comment with the error is returned.
+func meta(name, field string) template.HTML {
+	rel := filepath.FromSlash(strings.TrimPrefix(name, "/"))
+	metas.Lock()
+	v, ok := metas.vars[rel]
+	metas.Unlock()
+	if !ok {
+		path := sourcePath(rel)
+		if path == "" {
+			return template.HTML("<!-- meta " + name + ": no such page -->")
+		}
+		var err error
+		if v, _, err = getVars(path, metas.globals); err != nil {
+			return template.HTML("<!-- meta " + name + ": " + err.Error() + " -->")
+		}
+		metas.Lock()
+		metas.vars[rel] = v
+		metas.Unlock()
+	}
+	value, ok := v[field]
+	if !ok {
+		return template.HTML("<!-- meta " + name + ": no " + field + " variable -->")
+	}
+	return template.HTML(html.EscapeString(value))
+}
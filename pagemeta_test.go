@@ -0,0 +1,45 @@
+package z
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMeta(t *testing.T) {
+	defer chdirTemp(t)()
+	defer resetMetas(Vars{})
+
+	os.Mkdir("blog", 0755)
+	ioutil.WriteFile(filepath.Join("blog", "post.md"), []byte("title: Fish & Chips\n---\nHello\n"), 0644)
+	resetMetas(Vars{"author": "Me"})
+
+	tests := map[string]string{
+		`#{meta("blog/post.md", "title")}`:    "Fish &amp; Chips\n",
+		`#{meta("/blog/post.md", "url")}`:     "blog/post.html\n",
+		`#{meta("blog/post.md", "author")}`:   "Me\n",
+		`#{meta("blog/post.md", "nope")}`:     "<!-- meta blog/post.md: no nope variable -->\n",
+		`#{meta("blog/missing.md", "title")}`: "<!-- meta blog/missing.md: no such page -->\n",
+	}
+	for script, expected := range tests {
+		ioutil.WriteFile("test.amber", []byte(script+"\n"), 0644)
+		buf := &bytes.Buffer{}
+		if err := buildAmber("test.amber", buf, Vars{}); err != nil {
+			t.Error(err)
+		} else if buf.String() != expected {
+			t.Error(script, buf.String())
+		}
+	}
+
+	// Loaded variables are cached during a build
+	ioutil.WriteFile(filepath.Join("blog", "post.md"), []byte("title: Changed\n---\nHello\n"), 0644)
+	if s := meta("blog/post.md", "title"); s != "Fish &amp; Chips" {
+		t.Error(s)
+	}
+	resetMetas(Vars{})
+	if s := meta("blog/post.md", "title"); s != "Changed" {
+		t.Error(s)
+	}
+}
@@ -0,0 +1,104 @@
+package z
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// pageSize returns the number of pages listed on each page of the paginated
+// index page, or 0 if the page is not paginated. Only index.amber pages are
+// paginated, the size is taken from the "paginate" variable.
+func pageSize(path string, vars Vars) int {
+	if filepath.Base(path) != "index.amber" {
+		return 0
+	}
+	if n, err := strconv.Atoi(vars["paginate"]); err == nil && n > 0 {
+		return n
+	}
+	return 0
+}
+
+// pageCount returns the number of pages of the paginated page listing n
+// pages, size pages on each of them. There is always at least one page.
+func pageCount(n, size int) int {
+	if n == 0 {
+		return 1
+	}
+	return (n + size - 1) / size
+}
+
+// pageNumURL returns the url of the n-th page of the paginated page, the
+// first page keeps the original url. Directory urls get the page number as a
+// subdirectory, e.g. "blog/2/".
+func pageNumURL(url string, n int) string {
+	if n == 1 {
+		return url
+	}
+	if url == "" || strings.HasSuffix(url, "/") {
+		return fmt.Sprintf("%s%d/", url, n)
+	}
+	return renameExt(url, ".html", fmt.Sprintf("-%d.html", n))
+}
+
+// paginate returns the slice of pages listed on the n-th page of the
+// paginated page, according to its variables
+func paginate(pages []Vars, vars Vars) []Vars {
+	size, err := strconv.Atoi(vars["paginate"])
+	if err != nil || size <= 0 {
+		return pages
+	}
+	n, err := strconv.Atoi(vars["page"])
+	if err != nil || n < 1 {
+		return pages
+	}
+	from, to := (n-1)*size, n*size
+	if from > len(pages) {
+		from = len(pages)
+	}
+	if to > len(pages) {
+		to = len(pages)
+	}
+	return pages[from:to]
+}
+
+// buildPaginated renders the index page into as many pages as needed to
+// list all the pages, e.g. index.html, index-2.html and so on. Every page
+// gets its number as "page" and the urls of the previous and the next pages
+// as "prev" and "next" variables.
+func buildPaginated(path string, vars Vars, size int) error {
+	count := pageCount(len(pages), size)
+	url := pageURL(relPath(path), vars)
+	for n := 1; n <= count; n++ {
+		v := Vars{}
+		for name, value := range vars {
+			v[name] = value
+		}
+		v["paginate"] = strconv.Itoa(size)
+		v["page"] = strconv.Itoa(n)
+		v["url"] = pageNumURL(url, n)
+		v["prev"], v["next"] = "", ""
+		if n > 1 {
+			v["prev"] = pageNumURL(url, n-1)
+		}
+		if n < count {
+			v["next"] = pageNumURL(url, n+1)
+		}
+		if ok, err := claimOutput(urlOutput(v["url"]), path, v); err != nil {
+			return err
+		} else if !ok {
+			continue
+		}
+		f, err := createOutput(urlOutput(v["url"]))
+		if err != nil {
+			return err
+		}
+		err = renderAmber(path, f, v, nil)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
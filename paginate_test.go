@@ -0,0 +1,73 @@
+package z
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPaginate(t *testing.T) {
+	defer chdirTemp(t)()
+	defer func() { pages = nil }()
+
+	os.Mkdir(PUBDIR, 0755)
+	ioutil.WriteFile("index.amber", []byte("p #{page} #{prev} #{next}\neach $p in pages\n\tspan #{$p.title}\n"), 0644)
+
+	pages = []Vars{{"title": "A"}, {"title": "B"}, {"title": "C"}, {"title": "D"}, {"title": "E"}}
+	if err := build("index.amber", nil, Vars{"paginate": "2"}); err != nil {
+		t.Fatal(err)
+	}
+	for path, expected := range map[string]string{
+		"index.html":   "<p>1  index-2.html</p>\n<span>A</span>\n<span>B</span>\n",
+		"index-2.html": "<p>2 index.html index-3.html</p>\n<span>C</span>\n<span>D</span>\n",
+		"index-3.html": "<p>3 index-2.html </p>\n<span>E</span>\n",
+	} {
+		b, err := ioutil.ReadFile(filepath.Join(PUBDIR, path))
+		if err != nil {
+			t.Error(err)
+		} else if string(b) != expected {
+			t.Error(path, string(b))
+		}
+	}
+	if _, err := os.Stat(filepath.Join(PUBDIR, "index-4.html")); err == nil {
+		t.Error("too many pages")
+	}
+	// Pages that are no longer needed to list all the pages are stale
+	for _, name := range []string{"a", "b", "c"} {
+		ioutil.WriteFile(name+".md", []byte(name), 0644)
+	}
+	ioutil.WriteFile(filepath.Join(PUBDIR, "index-2.html.gz"), []byte{}, 0644)
+	if err := build("index.amber", nil, Vars{"paginate": "2"}); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("ZS_PAGINATE", "2")
+	defer os.Unsetenv("ZS_PAGINATE")
+	if err := cleanStale(); err != nil {
+		t.Fatal(err)
+	}
+	for path, exists := range map[string]bool{
+		"index.html":      true,
+		"index-2.html":    true,
+		"index-2.html.gz": true,
+		"index-3.html":    false,
+	} {
+		if _, err := os.Stat(filepath.Join(PUBDIR, path)); (err == nil) != exists {
+			t.Error(path, exists, err)
+		}
+	}
+
+	os.RemoveAll(PUBDIR)
+	os.Mkdir(PUBDIR, 0755)
+	if err := build("index.amber", nil, Vars{"paginate": "10"}); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(PUBDIR, "index.html")); err != nil {
+		t.Error(err)
+	} else if string(b) != "<p>1  </p>\n<span>A</span>\n<span>B</span>\n<span>C</span>\n<span>D</span>\n<span>E</span>\n" {
+		t.Error(string(b))
+	}
+	if _, err := os.Stat(filepath.Join(PUBDIR, "index-2.html")); err == nil {
+		t.Error("too many pages")
+	}
+}
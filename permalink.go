@@ -0,0 +1,72 @@
+package z
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Tokens of the permalink pattern, e.g. ":year"
+var permalinkRe = regexp.MustCompile(`:[a-z]+`)
+
+// permalink returns the url of the page built from the permalink pattern of
+// the page, e.g. "/:year/:month/:slug/". Tokens are :year, :month and :day
+// of the page date, :slug of the page title (or "slug" variable) and :section,
+// the top-level directory of the page. Urls without an extension are
+// directories.
+func permalink(file string, vars Vars) (string, error) {
+	var err error
+	url := permalinkRe.ReplaceAllStringFunc(vars["permalink"], func(token string) string {
+		value := ""
+		switch token {
+		case ":year", ":month", ":day":
+			date, e := parseDate(vars["date"])
+			if e != nil {
+				err = fmt.Errorf("permalink: %s requires a valid date", token)
+				return ""
+			}
+			value = map[string]string{
+				":year":  date.Format("2006"),
+				":month": date.Format("01"),
+				":day":   date.Format("02"),
+			}[token]
+		case ":slug":
+			value = vars["slug"]
+			if value == "" {
+				value = slugify(vars["title"])
+			}
+		case ":section":
+			if parts := strings.Split(filepath.ToSlash(relPath(file)), "/"); len(parts) > 1 {
+				value = parts[0]
+			}
+		default:
+			err = errors.New("permalink: unknown token " + token)
+			return ""
+		}
+		if value == "" && err == nil {
+			err = errors.New("permalink: " + token + " is empty")
+		}
+		return value
+	})
+	if err != nil {
+		return "", err
+	}
+	dir := strings.HasSuffix(url, "/") || path.Ext(url) == ""
+	url = strings.TrimPrefix(path.Clean("/"+url), "/")
+	if dir && url != "" {
+		url = url + "/"
+	}
+	return url, nil
+}
+
+// pageOutput returns the output path (relative to the output directory) of
+// the page with the given variables v, built with the global variables
+func pageOutput(file string, vars, v Vars) string {
+	if v["permalink"] != "" {
+		return urlOutput(v["url"])
+	}
+	return urlOutput(pageURL(relPath(file), vars))
+}
@@ -0,0 +1,78 @@
+package z
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPermalink(t *testing.T) {
+	for _, test := range []struct {
+		file, permalink, date, title, url string
+	}{
+		{"blog/post.md", "/:year/:month/:day/:slug/", "2015-08-28", "Hello World", "2015/08/28/hello-world/"},
+		{"blog/post.md", "/:section/:slug.html", "", "Hello World", "blog/hello-world.html"},
+		{"post.md", "/:slug", "", "Über uns", "uber-uns/"},
+		{"post.md", "/", "", "Home", ""},
+	} {
+		v := Vars{"permalink": test.permalink, "date": test.date, "title": test.title}
+		if url, err := permalink(test.file, v); err != nil || url != test.url {
+			t.Error(test, url, err)
+		}
+	}
+	for file, v := range map[string]Vars{
+		"blog/post.md": {"permalink": "/:year/:slug/", "title": "Post"},
+		"post.md":      {"permalink": "/:section/:slug/", "title": "Post"},
+		"other.md":     {"permalink": "/:category/", "title": "Post"},
+	} {
+		if url, err := permalink(file, v); err == nil {
+			t.Error(file, url)
+		}
+	}
+}
+
+func TestPermalinkBuild(t *testing.T) {
+	defer chdirTemp(t)()
+
+	os.Mkdir(ZSDIR, 0755)
+	os.MkdirAll(filepath.Join(PUBDIR, "blog"), 0755)
+	os.Mkdir("blog", 0755)
+	ioutil.WriteFile(ZSDIR+"/layout.amber", []byte("div #{url}"), 0644)
+	ioutil.WriteFile("blog/post.md", []byte("permalink: /:year/:slug/\ndate: 2015-08-28\ntitle: Hello\n---\nText"), 0644)
+
+	v, _, err := getVars("blog/post.md", Vars{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v["url"] != "2015/hello/" || v["root"] != "../../" {
+		t.Error(v)
+	}
+	if !needsRebuild("blog/post.md", filepath.Join(PUBDIR, "blog/post.html"), Vars{}) {
+		t.Error("missing permalink output is not rebuilt")
+	}
+	if err := build("blog/post.md", nil, Vars{}); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(PUBDIR, "2015/hello/index.html")); err != nil {
+		t.Error(err)
+	} else if string(b) != "<div>2015/hello/</div>\n" {
+		t.Error(string(b))
+	}
+	if needsRebuild("blog/post.md", filepath.Join(PUBDIR, "blog/post.html"), Vars{}) {
+		t.Error("permalink output is rebuilt")
+	}
+
+	ioutil.WriteFile("blog/feed.amber", []byte("permalink: /feed/\n---\np feed"), 0644)
+	if err := build("blog/feed.amber", nil, Vars{}); err != nil {
+		t.Fatal(err)
+	}
+	if needsRebuild("blog/feed.amber", filepath.Join(PUBDIR, "blog/feed.html"), Vars{}) {
+		t.Error("amber permalink output is rebuilt")
+	}
+
+	ioutil.WriteFile("blog/post.md", []byte("permalink: /:year/:slug/\n---\nText"), 0644)
+	if _, _, err := getVars("blog/post.md", Vars{}); err == nil {
+		t.Error("permalink without date")
+	}
+}
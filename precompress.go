@@ -0,0 +1,78 @@
+package z
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Smaller files are not worth compressing
+const minPrecompressSize = 256
+
+// Extensions of the text outputs that are precompressed. Images, archives
+// and other binary formats are usually compressed already.
+var precompressExts = map[string]bool{
+	".html": true, ".css": true, ".js": true, ".svg": true,
+	".xml": true, ".json": true, ".txt": true,
+}
+
+// precompress writes gzipped copies of the text outputs next to them, e.g.
+// style.css.gz for style.css, if "precompress" variable lists gzip. Copies
+// are only rewritten if the output is newer. Brotli is not supported and only
+// warned about.
+func precompress(vars Vars) error {
+	enabled := false
+	for _, format := range strings.Split(vars["precompress"], ",") {
+		switch strings.TrimSpace(format) {
+		case "":
+		case "gzip":
+			enabled = true
+		case "br":
+			logWarn("precompress: brotli is not supported, skipped")
+		default:
+			return errors.New("precompress: unknown format " + format)
+		}
+	}
+	if !enabled {
+		return nil
+	}
+	return filepath.Walk(pubDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !precompressExts[filepath.Ext(path)] ||
+			info.Size() < minPrecompressSize {
+			return err
+		}
+		if gz, err := os.Stat(path + ".gz"); err == nil && !info.ModTime().After(gz.ModTime()) {
+			return nil
+		}
+		return gzipFile(path, info)
+	})
+}
+
+// gzipFile writes the gzipped copy of the file with the same modification time
+func gzipFile(path string, info os.FileInfo) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(path + ".gz")
+	if err != nil {
+		return err
+	}
+	zw, _ := gzip.NewWriterLevel(out, gzip.BestCompression)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(path+".gz", info.ModTime(), info.ModTime())
+}
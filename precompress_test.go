@@ -0,0 +1,103 @@
+package z
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPrecompress(t *testing.T) {
+	defer chdirTemp(t)()
+
+	page := []byte("<p>" + strings.Repeat("Hello, world! ", 50) + "</p>")
+	os.MkdirAll(filepath.Join(PUBDIR, "blog"), 0755)
+	ioutil.WriteFile(filepath.Join(PUBDIR, "blog", "index.html"), page, 0644)
+	ioutil.WriteFile(filepath.Join(PUBDIR, "style.css"), []byte("p{}"), 0644)
+	ioutil.WriteFile(filepath.Join(PUBDIR, "photo.png"), page, 0644)
+
+	if err := precompress(Vars{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(PUBDIR, "blog", "index.html.gz")); err == nil {
+		t.Error("precompressed when disabled")
+	}
+	if err := precompress(Vars{"precompress": "br"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(PUBDIR, "blog", "index.html.gz")); err == nil {
+		t.Error("gzipped without gzip")
+	}
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+	if err := precompress(Vars{"precompress": "gzip, br"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "WARN: precompress: brotli is not supported") {
+		t.Error(buf.String())
+	}
+	f, err := os.Open(filepath.Join(PUBDIR, "blog", "index.html.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadAll(zr); err != nil || !bytes.Equal(b, page) {
+		t.Error(string(b), err)
+	}
+	for _, name := range []string{"style.css.gz", "photo.png.gz"} {
+		if _, err := os.Stat(filepath.Join(PUBDIR, name)); err == nil {
+			t.Error(name)
+		}
+	}
+	for path, expected := range map[string][]string{
+		"blog/index.html.gz": {"blog/index.html", "blog/index.amber", "blog/index.md", "blog/index.mkd", "blog/index.markdown"},
+		"style.css.gz":       {"style.css", "style.css", "style.gcss"},
+	} {
+		if srcs := sources(path); strings.Join(srcs, " ") != strings.Join(expected, " ") {
+			t.Error(path, srcs)
+		}
+	}
+}
+
+func TestServeGzip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "app.js"), []byte("plain"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "app.js.gz"), []byte("gzipped"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "page.html"), []byte("page"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "page.html.gz"), []byte("gzipped"), 0644)
+	h := serveGzip(dir, http.FileServer(http.Dir(dir)))
+
+	for _, test := range []struct {
+		url, encoding, body string
+	}{
+		{"/app.js", "gzip, deflate", "gzipped"},
+		{"/app.js", "", "plain"},
+		{"/page.html", "gzip", "page"},
+	} {
+		r := httptest.NewRequest("GET", test.url, nil)
+		r.Header.Set("Accept-Encoding", test.encoding)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Body.String() != test.body {
+			t.Error(test.url, test.encoding, w.Body.String())
+		}
+		if gz := w.Header().Get("Content-Encoding") == "gzip"; gz != (test.body == "gzipped") {
+			t.Error(test.url, test.encoding, w.Header())
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package z
+
+import (
+	"fmt"
+	"os"
+	"runtime/pprof"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Number of the slowest files in the profile summary
+const profileTopFiles = 10
+
+// profiling keeps the time spent in the build phases and on every file during
+// a build, if --profile flag is given. Phases of the files built in parallel
+// are summed up.
+var profiling = struct {
+	sync.Mutex
+	phases map[string]time.Duration
+	files  map[string]time.Duration
+}{phases: map[string]time.Duration{}, files: map[string]time.Duration{}}
+
+// resetProfile clears the timings of the printed build
+func resetProfile() {
+	profiling.Lock()
+	defer profiling.Unlock()
+	profiling.phases = map[string]time.Duration{}
+	profiling.files = map[string]time.Duration{}
+}
+
+// noop is returned by track when profiling is off
+func noop() {}
+
+// track starts timing the build phase, the returned function stops it, e.g.
+// defer track("write")()
+func track(phase string) func() {
+	if !*profile {
+		return noop
+	}
+	start := time.Now()
+	return func() {
+		d := time.Since(start)
+		profiling.Lock()
+		profiling.phases[phase] += d
+		profiling.Unlock()
+	}
+}
+
+// trackFile records the time spent on building the file
+func trackFile(path string, d time.Duration) {
+	if !*profile {
+		return
+	}
+	profiling.Lock()
+	profiling.files[path] += d
+	profiling.Unlock()
+}
+
+// printProfile prints the time spent in every phase and the slowest files
+func printProfile(total time.Duration) {
+	profiling.Lock()
+	defer profiling.Unlock()
+	fmt.Fprintf(os.Stderr, "profile: total %v\n", total)
+	phases := []string{}
+	for phase := range profiling.phases {
+		phases = append(phases, phase)
+	}
+	sort.Slice(phases, func(i, j int) bool {
+		return profiling.phases[phases[i]] > profiling.phases[phases[j]]
+	})
+	for _, phase := range phases {
+		fmt.Fprintf(os.Stderr, "profile: %-8s %v\n", phase, profiling.phases[phase])
+	}
+	files := []string{}
+	for path := range profiling.files {
+		files = append(files, path)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if profiling.files[files[i]] == profiling.files[files[j]] {
+			return files[i] < files[j]
+		}
+		return profiling.files[files[i]] > profiling.files[files[j]]
+	})
+	if len(files) > profileTopFiles {
+		files = files[:profileTopFiles]
+	}
+	for _, path := range files {
+		fmt.Fprintf(os.Stderr, "profile: %v %s\n", profiling.files[path], path)
+	}
+}
+
+// cpuProfile is the file the CPU profile is written to
+var cpuProfile *os.File
+
+// startCPUProfile writes the CPU profile into the file until stopCPUProfile
+// is called
+func startCPUProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := pprof.StartCPUProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	cpuProfile = f
+	return nil
+}
+
+// stopCPUProfile stops the CPU profile, if any
+func stopCPUProfile() {
+	if cpuProfile != nil {
+		pprof.StopCPUProfile()
+		cpuProfile.Close()
+		cpuProfile = nil
+	}
+}
+
+// osExit exits the process, replaced in tests
+var osExit = os.Exit
+
+// exit stops the CPU profile and exits with the given status
+func exit(code int) {
+	stopCPUProfile()
+	osExit(code)
+}
@@ -0,0 +1,54 @@
+package z
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() { srcDir, pubDir = ".", PUBDIR }()
+
+	site := &Site{SrcDir: dir, PubDir: filepath.Join(dir, PUBDIR), Vars: Vars{}}
+	os.Mkdir(filepath.Join(dir, ZSDIR), 0755)
+	ioutil.WriteFile(filepath.Join(dir, ZSDIR, "layout.amber"), []byte("div #{unescaped(content)}"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "index.md"), []byte("# Hello"), 0644)
+
+	track("walk")()
+	trackFile("index.md", 1)
+	if len(profiling.phases) != 0 || len(profiling.files) != 0 {
+		t.Error("profiled without --profile", profiling.phases, profiling.files)
+	}
+
+	*profile = true
+	defer func() { *profile = false }()
+	defer site.use()()
+	vars := pageGlobals(site.Vars)
+	path := filepath.Join(dir, "index.md")
+	stop := track("walk")
+	stop()
+	resetMetas(vars)
+	resetWritten()
+	pages = collectPages(vars)
+	if n, errs := buildFiles([]string{path}, vars); n != 1 || len(errs) > 0 {
+		t.Fatal(n, errs)
+	}
+	for _, phase := range []string{"walk", "markdown", "layout", "write"} {
+		if _, ok := profiling.phases[phase]; !ok {
+			t.Error("no phase", phase, profiling.phases)
+		}
+	}
+	if d, ok := profiling.files[path]; !ok || d <= 0 {
+		t.Error(profiling.files)
+	}
+	resetProfile()
+	if len(profiling.phases) != 0 || len(profiling.files) != 0 {
+		t.Error(profiling.phases, profiling.files)
+	}
+}
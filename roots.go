@@ -0,0 +1,160 @@
+package z
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// srcDirs are all the source directories of the site, in order. Files in the
+// later directories override the files with the same path in the earlier
+// ones. The main source directory srcDir is the last one.
+var srcDirs []string
+
+// stringList is a flag that can be given several times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// roots returns the source directories of the site
+func roots() []string {
+	if len(srcDirs) == 0 {
+		return []string{srcDir}
+	}
+	return srcDirs
+}
+
+// rootOf returns the source directory the path belongs to, the closest one
+// if the source directories are nested
+func rootOf(path string) string {
+	root, depth := srcDir, -1
+	for _, dir := range roots() {
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if n := strings.Count(rel, string(filepath.Separator)); depth == -1 || n < depth {
+			root, depth = dir, n
+		}
+	}
+	return root
+}
+
+// sourcePath returns the path of the source file with the given path relative
+// to the source directories, or an empty string if there is no such file.
+// Later source directories take precedence.
+func sourcePath(rel string) string {
+	dirs := roots()
+	for i := len(dirs) - 1; i >= 0; i-- {
+		path := filepath.Join(dirs[i], rel)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// overridden returns true if the source file is overridden by the file with
+// the same path in a later source directory
+func overridden(path string) bool {
+	if len(srcDirs) < 2 {
+		return false
+	}
+	return sourcePath(relPath(path)) != filepath.Clean(path)
+}
+
+// walkAll walks every source directory like walk does, overridden files are
+// not returned
+func walkAll(since time.Time) (dirs []string, paths []string) {
+	for _, root := range roots() {
+		d, p := walk(root, since)
+		dirs = append(dirs, d...)
+		for _, path := range p {
+			if !overridden(path) {
+				paths = append(paths, path)
+			}
+		}
+	}
+	return dirs, paths
+}
+
+// walkSources calls fn for every file of the source directories that is not
+// hidden or overridden, sorted by the path relative to the source directories
+func walkSources(fn func(path string, info os.FileInfo) error) error {
+	type source struct {
+		path string
+		info os.FileInfo
+	}
+	files := map[string]source{}
+	for _, root := range roots() {
+		walkTree(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil || hidden(path) {
+				if err == nil && info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !info.IsDir() {
+				files[relPath(path)] = source{path, info}
+			}
+			return nil
+		})
+	}
+	rels := []string{}
+	for rel := range files {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+	for _, rel := range rels {
+		if err := fn(files[rel].path, files[rel].info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// themeDir is the directory with the service files of a shared theme, set
+// with ZS_THEMEDIR. Files in the .zs directories override the theme files.
+var themeDir string
+
+// zsDirs returns the directories with the service files, the later ones
+// override the earlier: the theme directory, the .zs directories of the
+// source directories and ZSDIR
+func zsDirs() []string {
+	dirs := []string{}
+	if themeDir != "" {
+		dirs = append(dirs, themeDir)
+	}
+	for _, root := range roots() {
+		dirs = append(dirs, filepath.Join(root, ZSDIR))
+	}
+	return append(dirs, ZSDIR)
+}
+
+// zsPath returns the path of the service file (layout, partial or script)
+// with the given name. It's looked up in ZSDIR first, then in the .zs
+// directories of the source directories, from the last one, then in the
+// theme directory.
+func zsPath(name string) string {
+	path := filepath.Join(ZSDIR, name)
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+	dirs := zsDirs()
+	for i := len(dirs) - 1; i >= 0; i-- {
+		p := filepath.Join(dirs[i], name)
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return path
+}
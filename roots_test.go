@@ -0,0 +1,99 @@
+package z
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSrcDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() { srcDir, srcDirs, pubDir = ".", nil, PUBDIR }()
+
+	shared, main := filepath.Join(dir, "shared"), filepath.Join(dir, "site")
+	files := map[string]string{
+		filepath.Join(shared, ZSDIR, "layout.amber"): "div #{unescaped(content)}",
+		filepath.Join(shared, "about.md"):            "Shared about",
+		filepath.Join(shared, "docs", "guide.md"):    "Guide",
+		filepath.Join(shared, "style.css"):           "shared",
+		filepath.Join(main, "about.md"):              "Site about",
+		filepath.Join(main, "index.md"):              "Index",
+		filepath.Join(main, "style.css"):             "site",
+	}
+	for path, content := range files {
+		os.MkdirAll(filepath.Dir(path), 0755)
+		ioutil.WriteFile(path, []byte(content), 0644)
+	}
+
+	site := &Site{SrcDir: main, SrcDirs: []string{shared, main}, PubDir: filepath.Join(dir, "public"), Vars: Vars{}}
+	site.Build()
+	for path, expected := range map[string]string{
+		"about.html":      "<div><p>Site about</p>\n</div>\n",
+		"index.html":      "<div><p>Index</p>\n</div>\n",
+		"docs/guide.html": "<div><p>Guide</p>\n</div>\n",
+		"style.css":       "site",
+	} {
+		if b, err := ioutil.ReadFile(filepath.Join(site.PubDir, path)); err != nil {
+			t.Error(err)
+		} else if string(b) != expected {
+			t.Error(path, string(b))
+		}
+	}
+
+	if len(pages) != 3 {
+		t.Error(pages)
+	}
+	for _, p := range pages {
+		if p["url"] == "about.html" && p["file"] != filepath.Join(main, "about.md") {
+			t.Error(p)
+		}
+	}
+	if !overridden(filepath.Join(shared, "about.md")) || overridden(filepath.Join(main, "about.md")) ||
+		overridden(filepath.Join(shared, "docs", "guide.md")) {
+		t.Error("wrong overrides")
+	}
+	if rel := relPath(filepath.Join(shared, "docs", "guide.md")); rel != filepath.Join("docs", "guide.md") {
+		t.Error(rel)
+	}
+}
+
+func TestThemeDir(t *testing.T) {
+	defer chdirTemp(t)()
+	defer func() { srcDir, pubDir, themeDir = ".", PUBDIR, "" }()
+
+	dir, _ := os.Getwd()
+	theme := filepath.Join(dir, "theme")
+	files := map[string]string{
+		filepath.Join(theme, "layout.amber"):           "main #{unescaped(content)}",
+		filepath.Join(theme, "post.amber"):             "article #{unescaped(content)}",
+		filepath.Join(theme, DATADIR, "menu.yaml"):     "[Theme]",
+		filepath.Join(ZSDIR, "post.amber"):             "section #{unescaped(content)}",
+		filepath.Join("src", "about.md"):               "About",
+		filepath.Join("src", "blog.md"):                "layout: post.amber\n---\nBlog",
+		filepath.Join("src", ZSDIR, DATADIR, "x.yaml"): "a: b",
+	}
+	for path, content := range files {
+		os.MkdirAll(filepath.Dir(path), 0755)
+		ioutil.WriteFile(path, []byte(content), 0644)
+	}
+	site := &Site{SrcDir: "src", PubDir: filepath.Join(dir, "pub"), Vars: Vars{"themedir": theme}}
+	if err := site.Build(); err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{
+		"about.html": "<main><p>About</p>\n</main>\n",
+		"blog.html":  "<section><p>Blog</p>\n</section>\n",
+	} {
+		if b, err := ioutil.ReadFile(filepath.Join(site.PubDir, name)); err != nil || string(b) != expected {
+			t.Error(name, string(b), err)
+		}
+	}
+	if len(dataFiles()) != 2 {
+		t.Error(dataFiles())
+	}
+}
@@ -0,0 +1,99 @@
+package z
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// RULES is the file in ZSDIR mapping glob patterns of the source files to
+// their default variables, e.g. "blog/**: {layout: post.amber}"
+const RULES = "rules.yaml"
+
+// rule is a pattern of the rules file with its variables
+type rule struct {
+	re   *regexp.Regexp
+	vars Vars
+}
+
+// rules caches the rules file during a build
+var rules = struct {
+	sync.Mutex
+	loaded bool
+	list   []rule
+}{}
+
+// resetRules clears the rules cache, so that the rules file is read again
+func resetRules() {
+	rules.Lock()
+	defer rules.Unlock()
+	rules.loaded = false
+	rules.list = nil
+}
+
+// loadRules returns the rules of the rules file in order. Broken rules files
+// are reported once per build and ignored.
+func loadRules() []rule {
+	rules.Lock()
+	defer rules.Unlock()
+	if rules.loaded {
+		return rules.list
+	}
+	rules.loaded = true
+	b, err := ioutil.ReadFile(zsPath(RULES))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logError(err.Error())
+		}
+		return nil
+	}
+	list, err := parseRules(b)
+	if err != nil {
+		logError(RULES + ": " + err.Error())
+		return nil
+	}
+	rules.list = list
+	return list
+}
+
+// parseRules parses the rules file content, keeping the order of the rules
+func parseRules(b []byte) ([]rule, error) {
+	slice := yaml.MapSlice{}
+	if err := yaml.Unmarshal(b, &slice); err != nil {
+		return nil, err
+	}
+	list := []rule{}
+	for _, item := range slice {
+		values, ok := item.Value.(yaml.MapSlice)
+		if !ok {
+			return nil, fmt.Errorf("%v: variables expected", item.Key)
+		}
+		r := rule{re: regexp.MustCompile("^" + globRegexp(fmt.Sprint(item.Key)) + "$"), vars: Vars{}}
+		for _, value := range values {
+			r.vars[fmt.Sprint(value.Key)] = fmt.Sprint(value.Value)
+		}
+		list = append(list, r)
+	}
+	return list, nil
+}
+
+// ruleDefaults returns the variables of all the rules matching the source
+// file path (relative to the source directory). Later rules override the
+// variables of the earlier ones.
+func ruleDefaults(path string) Vars {
+	v := Vars{}
+	rel := filepath.ToSlash(relPath(path))
+	for _, r := range loadRules() {
+		if r.re.MatchString(rel) {
+			for name, value := range r.vars {
+				v[name] = value
+			}
+		}
+	}
+	return v
+}
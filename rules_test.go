@@ -0,0 +1,45 @@
+package z
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRules(t *testing.T) {
+	defer chdirTemp(t)()
+	defer resetRules()
+	defer resetDefaults()
+
+	os.Mkdir(ZSDIR, 0755)
+	os.MkdirAll("blog/drafts", 0755)
+	ioutil.WriteFile(filepath.Join(ZSDIR, RULES), []byte("blog/**:\n  layout: post.amber\n  type: article\n"+
+		"blog/drafts/*:\n  type: draft\n  comments: false\n"), 0644)
+	ioutil.WriteFile("blog/drafts/"+DEFAULTS, []byte("comments: true\n"), 0644)
+	ioutil.WriteFile("blog/post.md", []byte("Post\n"), 0644)
+	ioutil.WriteFile("blog/drafts/idea.md", []byte("type: note\n---\nIdea\n"), 0644)
+	ioutil.WriteFile("about.md", []byte("About\n"), 0644)
+	resetRules()
+	resetDefaults()
+
+	for path, expected := range map[string]Vars{
+		"blog/post.md":        {"layout": "post.amber", "type": "article"},
+		"blog/drafts/idea.md": {"layout": "post.amber", "type": "note", "comments": "true"},
+		"about.md":            {"layout": "layout.html", "type": ""},
+	} {
+		v, _, err := getVars(path, Vars{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, value := range expected {
+			if v[name] != value {
+				t.Error(path, name, v[name])
+			}
+		}
+	}
+
+	if _, err := parseRules([]byte("blog/**: post.amber\n")); err == nil {
+		t.Error("rule without variables")
+	}
+}
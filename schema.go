@@ -0,0 +1,178 @@
+package z
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// SCHEMA is the file in ZSDIR declaring the types of the page variables and
+// the variables required in the headers of every page type, e.g.
+// "fields: {date: date}" and "required: {post: [title, date]}"
+const SCHEMA = "schema.yaml"
+
+// fieldTypes are the types of the schema fields with their value checks
+var fieldTypes = map[string]func(string) bool{
+	"string": func(string) bool { return true },
+	"list":   func(string) bool { return true },
+	"date": func(s string) bool {
+		_, err := parseDate(s)
+		return err == nil
+	},
+	"bool": func(s string) bool {
+		_, err := strconv.ParseBool(s)
+		return err == nil
+	},
+}
+
+// builtinVars are the page variables known without being declared in the
+// schema, as well as the global variables
+var builtinVars = map[string]bool{
+	"aliases": true, "date": true, "description": true, "draft": true, "formats": true,
+	"layout": true, "link": true, "paginate": true, "permalink": true, "root": true,
+	"slug": true, "tags": true, "title": true, "type": true, "url": true,
+}
+
+// schema declares the types of the page variables and the variables required
+// by the page types, "*" for all pages
+type schema struct {
+	Fields   map[string]string   `yaml:"fields"`
+	Required map[string][]string `yaml:"required"`
+}
+
+// schemas caches the schema file during a build
+var schemas = struct {
+	sync.Mutex
+	loaded bool
+	s      *schema
+}{}
+
+// resetSchema clears the schema cache, so that the schema file is read again
+func resetSchema() {
+	schemas.Lock()
+	defer schemas.Unlock()
+	schemas.loaded = false
+	schemas.s = nil
+}
+
+// loadSchema returns the schema of the site, or nil if there is none. Broken
+// schema files are reported once per build and ignored.
+func loadSchema() *schema {
+	schemas.Lock()
+	defer schemas.Unlock()
+	if schemas.loaded {
+		return schemas.s
+	}
+	schemas.loaded = true
+	b, err := ioutil.ReadFile(zsPath(SCHEMA))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logError(err.Error())
+		}
+		return nil
+	}
+	s, err := parseSchema(b)
+	if err != nil {
+		logError(SCHEMA + ": " + err.Error())
+		return nil
+	}
+	schemas.s = s
+	return s
+}
+
+// parseSchema parses the schema file content
+func parseSchema(b []byte) (*schema, error) {
+	s := &schema{}
+	if err := yaml.UnmarshalStrict(b, s); err != nil {
+		return nil, err
+	}
+	for name, t := range s.Fields {
+		if _, ok := fieldTypes[t]; !ok {
+			return nil, fmt.Errorf("%s: unknown type %s", name, t)
+		}
+	}
+	return s, nil
+}
+
+// validate returns the problems of the page header: variables required by
+// the page type that are missing and values that don't match their types.
+// Variables that are neither declared nor built-in or global are returned as
+// unknown.
+func (s *schema) validate(header, v, globals Vars) (problems []error, unknown []string) {
+	for _, t := range []string{"*", v["type"]} {
+		for _, name := range s.Required[t] {
+			if strings.TrimSpace(header[name]) == "" {
+				problems = append(problems, fmt.Errorf("missing required variable %s", name))
+			}
+		}
+	}
+	names := []string{}
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		t, ok := s.Fields[name]
+		if !ok {
+			if _, global := globals[name]; !builtinVars[name] && !global {
+				unknown = append(unknown, name)
+			}
+			continue
+		}
+		if !fieldTypes[t](header[name]) {
+			problems = append(problems, fmt.Errorf("%s is not a %s: %s", name, t, header[name]))
+		}
+	}
+	return problems, unknown
+}
+
+// pageHeader returns the variables defined in the header of the file
+func pageHeader(path string) (Vars, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	header, _, err := splitHeader(string(b))
+	if err != nil {
+		return nil, headerError(path, string(b), err)
+	}
+	return header, nil
+}
+
+// validatePage checks the page header against the schema, if any. Unknown
+// variables and problems are reported as warnings, problems are returned as
+// an error instead if ZS_STRICT is set to 1.
+func validatePage(path string, globals Vars) error {
+	s := loadSchema()
+	if s == nil {
+		return nil
+	}
+	header, err := pageHeader(path)
+	if err != nil {
+		return err
+	}
+	v, _, err := getVars(path, globals)
+	if err != nil {
+		return err
+	}
+	problems, unknown := s.validate(header, v, globals)
+	for _, name := range unknown {
+		logWarn(fmt.Sprintf("%s: unknown variable %s", path, name))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	if globals["strict"] == "1" {
+		return errorList(problems)
+	}
+	for _, err := range problems {
+		logWarn(fmt.Sprintf("%s: %v", path, err))
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package z
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSchema(t *testing.T) {
+	defer chdirTemp(t)()
+	defer resetSchema()
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+
+	os.Mkdir(ZSDIR, 0755)
+	ioutil.WriteFile(filepath.Join(ZSDIR, "layout.amber"), []byte("div #{unescaped(content)}\n"), 0644)
+	ioutil.WriteFile(filepath.Join(ZSDIR, SCHEMA), []byte("fields:\n  date: date\n  featured: bool\n"+
+		"required:\n  post: [title, date]\n"), 0644)
+	files := map[string]string{
+		"ok.md":      "type: post\ntitle: OK\ndate: 2015-08-28\nfeatured: true\n---\nOK\n",
+		"missing.md": "type: post\ntitle: Missing\n---\nNo date\n",
+		"baddate.md": "type: post\ntitle: Bad\ndate: yesterday\n---\nBad date\n",
+		"unknown.md": "titel: Typo\n---\nTypo\n",
+		"untyped.md": "Plain page\n",
+	}
+	for name, content := range files {
+		ioutil.WriteFile(name, []byte(content), 0644)
+	}
+	resetSchema()
+
+	for path, expected := range map[string]string{
+		"ok.md":      "",
+		"missing.md": "missing required variable date",
+		"baddate.md": "date is not a date: yesterday",
+		"unknown.md": "",
+		"untyped.md": "",
+	} {
+		buf.Reset()
+		err := build(path, &bytes.Buffer{}, Vars{"strict": "1"})
+		if expected == "" && err != nil {
+			t.Error(path, err)
+		} else if expected != "" && (err == nil || err.Error() != expected) {
+			t.Error(path, err)
+		}
+		if warned := strings.Contains(buf.String(), "unknown variable titel"); warned != (path == "unknown.md") {
+			t.Error(path, buf.String())
+		}
+	}
+
+	// Problems are only warned about without strict mode
+	buf.Reset()
+	if err := build("missing.md", &bytes.Buffer{}, Vars{}); err != nil {
+		t.Error(err)
+	} else if !strings.Contains(buf.String(), "WARN: missing.md: missing required variable date") {
+		t.Error(buf.String())
+	}
+
+	if _, err := parseSchema([]byte("fields:\n  date: time\n")); err == nil {
+		t.Error("unknown type accepted")
+	}
+}
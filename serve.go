@@ -0,0 +1,243 @@
+package z
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RELOADURL is the server-sent events endpoint browsers listen on for
+// reload notifications
+const RELOADURL = "/__zs/reload"
+
+// reloadScript is injected into every served HTML page
+const reloadScript = `<script>new EventSource("` + RELOADURL + `").onmessage = function() { location.reload(); };</script>`
+
+// reloader keeps track of connected browsers and notifies them whenever
+// the site has been rebuilt
+type reloader struct {
+	sync.Mutex
+	clients map[chan bool]bool
+}
+
+func newReloader() *reloader {
+	return &reloader{clients: map[chan bool]bool{}}
+}
+
+// reload sends a reload event to every connected browser
+func (r *reloader) reload() {
+	r.Lock()
+	defer r.Unlock()
+	for c := range r.clients {
+		select {
+		case c <- true:
+		default:
+		}
+	}
+}
+
+// close disconnects all browsers, so that the server can shut down
+func (r *reloader) close() {
+	r.Lock()
+	defer r.Unlock()
+	for c := range r.clients {
+		close(c)
+		delete(r.clients, c)
+	}
+}
+
+func (r *reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	c := make(chan bool, 1)
+	r.Lock()
+	r.clients[c] = true
+	r.Unlock()
+	defer func() {
+		r.Lock()
+		delete(r.clients, c)
+		r.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher.Flush()
+	for {
+		select {
+		case _, ok := <-c:
+			if !ok {
+				return
+			}
+			fmt.Fprint(w, "data: reload\n\n")
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// injectWriter buffers text/html responses so that the reload script can be
+// inserted before they are sent. Other content types are passed through.
+type injectWriter struct {
+	http.ResponseWriter
+	html   bool
+	status int
+	buf    bytes.Buffer
+}
+
+func (w *injectWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = status
+	if strings.HasPrefix(w.Header().Get("Content-Type"), "text/html") {
+		w.html = true
+		w.Header().Del("Content-Length")
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *injectWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.html {
+		return w.buf.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// flush writes buffered HTML with the reload script injected. The 404 page is
+// reloaded too, so that the missing pages show up once they are built.
+func (w *injectWriter) flush() {
+	if !w.html {
+		return
+	}
+	b := w.buf.Bytes()
+	if (w.status != http.StatusOK && w.status != http.StatusNotFound) || len(b) == 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+		w.ResponseWriter.Write(b)
+		return
+	}
+	if i := bytes.LastIndex(bytes.ToLower(b), []byte("</body>")); i != -1 {
+		b = append(b[:i:i], append([]byte(reloadScript), b[i:]...)...)
+	} else {
+		b = append(b, reloadScript...)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(b)
+}
+
+// injectReload wraps handler h to add the live reload script to HTML pages
+func injectReload(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		iw := &injectWriter{ResponseWriter: w}
+		h.ServeHTTP(iw, r)
+		iw.flush()
+	})
+}
+
+// serveGzip wraps handler h to serve the precompressed .gz copies of the files
+// from dir to the browsers accepting gzip. HTML pages are always served as is,
+// so that the reload script can be injected.
+func serveGzip(dir string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Clean("/" + r.URL.Path)
+		ext := path.Ext(name)
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") || ext == "" || ext == ".html" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		f, err := os.Open(filepath.Join(dir, filepath.FromSlash(name)) + ".gz")
+		if err != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil || info.IsDir() {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if t := mime.TypeByExtension(ext); t != "" {
+			w.Header().Set("Content-Type", t)
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Vary", "Accept-Encoding")
+		http.ServeContent(w, r, name, info.ModTime(), f)
+	})
+}
+
+// serveNotFound wraps handler h to serve 404.html from dir with the 404
+// status for the missing files, if there is such page
+func serveNotFound(dir string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := filepath.Join(dir, filepath.FromSlash(path.Clean("/"+r.URL.Path)))
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			if b, err := ioutil.ReadFile(filepath.Join(dir, "404.html")); err == nil {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.WriteHeader(http.StatusNotFound)
+				w.Write(b)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// serve builds and watches the site, serving the output directory over HTTP
+// on the given address until interrupted. It fails right away if the address
+// can't be listened on.
+func serve(site *Site, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("can't serve on %s: %v", addr, err)
+	}
+	lr := newReloader()
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+	go site.Watch(watchCtx, lr.reload)
+
+	mux := http.NewServeMux()
+	mux.Handle(RELOADURL, lr)
+	mux.Handle("/", injectReload(serveNotFound(site.PubDir, serveGzip(site.PubDir, http.FileServer(http.Dir(site.PubDir))))))
+	srv := &http.Server{Addr: addr, Handler: mux}
+	srv.RegisterOnShutdown(lr.close)
+
+	done := make(chan bool)
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		<-sig
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			logError("serve: " + err.Error())
+		}
+		close(done)
+	}()
+
+	logInfo("serve:", addr)
+	if err := srv.Serve(ln); err != http.ErrServerClosed {
+		return err
+	}
+	<-done
+	return nil
+}
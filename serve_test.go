@@ -0,0 +1,95 @@
+package z
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInjectReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte("<html><body><p>Hello</p></body></html>"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "styles.css"), []byte("body{color:red;}"), 0644)
+
+	ts := httptest.NewServer(injectReload(http.FileServer(http.Dir(dir))))
+	defer ts.Close()
+
+	tests := map[string]string{
+		"/index.html": "<html><body><p>Hello</p>" + reloadScript + "</body></html>",
+		"/styles.css": "body{color:red;}",
+	}
+	for path, expected := range tests {
+		res, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if string(b) != expected {
+			t.Error(path, string(b))
+		}
+		if strings.HasSuffix(path, ".html") && res.ContentLength != int64(len(expected)) {
+			t.Error(path, res.ContentLength)
+		}
+	}
+}
+
+func TestServeAddrInUse(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	dir, err := ioutil.TempDir("", "zs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	site := &Site{SrcDir: dir, PubDir: filepath.Join(dir, PUBDIR), Vars: Vars{}}
+	err = serve(site, ln.Addr().String())
+	if err == nil || !strings.Contains(err.Error(), ln.Addr().String()) {
+		t.Error(err)
+	}
+}
+
+func TestServeNotFound(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "page.html"), []byte("page"), 0644)
+	h := serveNotFound(dir, http.FileServer(http.Dir(dir)))
+
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		return w
+	}
+	if w := get("/missing.html"); w.Code != http.StatusNotFound || w.Body.String() == "Not found" {
+		t.Error(w.Code, w.Body.String())
+	}
+	ioutil.WriteFile(filepath.Join(dir, "404.html"), []byte("Not found"), 0644)
+	if w := get("/missing.html"); w.Code != http.StatusNotFound || w.Body.String() != "Not found" {
+		t.Error(w.Code, w.Body.String())
+	}
+	if w := get("/page.html"); w.Code != http.StatusOK || w.Body.String() != "page" {
+		t.Error(w.Code, w.Body.String())
+	}
+
+	h = injectReload(h)
+	ioutil.WriteFile(filepath.Join(dir, "404.html"), []byte("<body>Not found</body>"), 0644)
+	if w := get("/missing.html"); w.Code != http.StatusNotFound || w.Body.String() != "<body>Not found"+reloadScript+"</body>" {
+		t.Error(w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,134 @@
+package z
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Site is a static site built from the source directory into the output
+// directory. Vars are the global variables available to all the pages.
+// If SrcDirs are given, the site is merged from all of them, files in the
+// later directories override the earlier ones and SrcDir is the last one.
+//
+// Several sites may be used in one process, even from different goroutines,
+// but only one of them is built at a time.
+type Site struct {
+	SrcDir  string
+	SrcDirs []string
+	PubDir  string
+	Vars    Vars
+
+	// Force rebuilds the files even if they are up to date, DryRun only
+	// reports what would be built, Drafts and Future build the draft pages
+	// and the pages dated in the future
+	Force, DryRun, Drafts, Future bool
+
+	// State kept between the builds of the site
+	assets       map[string]string
+	scheduled    map[string]time.Time
+	pagesModTime time.Time
+}
+
+// NewSite returns a site configured with the ZS_* environment variables, the
+// config file and the command line flags
+func NewSite() *Site {
+	vars := globals()
+	s := &Site{SrcDir: ".", PubDir: PUBDIR, Vars: vars,
+		Force: *force, DryRun: *dryRun, Drafts: *drafts, Future: *future}
+	if vars["srcdir"] != "" {
+		s.SrcDir = filepath.Clean(vars["srcdir"])
+	}
+	if vars["pubdir"] != "" {
+		s.PubDir = filepath.Clean(vars["pubdir"])
+	}
+	dirs := []string(*srcs)
+	if len(dirs) == 0 && vars["srcdirs"] != "" {
+		dirs = strings.Split(vars["srcdirs"], ",")
+	}
+	for _, dir := range dirs {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			s.SrcDirs = append(s.SrcDirs, filepath.Clean(dir))
+		}
+	}
+	if len(s.SrcDirs) > 0 {
+		s.SrcDir = s.SrcDirs[len(s.SrcDirs)-1]
+	}
+	return s
+}
+
+// building is locked while a site is being built, as the builders keep the
+// site directories, settings and state in package variables
+var building sync.Mutex
+
+// use locks the builders and makes them work with the site directories,
+// settings and state until the returned function is called
+func (s *Site) use() func() {
+	building.Lock()
+	if s.assets == nil {
+		s.assets, s.scheduled = map[string]string{}, map[string]time.Time{}
+	}
+	assets.paths, scheduled.pages, pagesModTime = s.assets, s.scheduled, s.pagesModTime
+	*force, *dryRun, *drafts, *future = s.Force, s.DryRun, s.Drafts, s.Future
+	srcDir, srcDirs, pubDir = s.SrcDir, s.SrcDirs, s.PubDir
+	followSymlinks = s.Vars["follow_symlinks"] == "1"
+	themeDir = s.Vars["themedir"]
+	mdExts = defaultMdExts
+	if s.Vars["md_ext"] != "" {
+		mdExts = []string{}
+		for _, ext := range strings.Split(s.Vars["md_ext"], ",") {
+			if ext = strings.TrimSpace(ext); ext != "" {
+				mdExts = append(mdExts, "."+strings.TrimPrefix(ext, "."))
+			}
+		}
+	}
+	return func() {
+		s.assets, s.scheduled, s.pagesModTime = assets.paths, scheduled.pages, pagesModTime
+		building.Unlock()
+	}
+}
+
+// Build builds every file of the site. If some files fail to build, the
+// rest is still built and all the errors are returned as one.
+func (s *Site) Build() error {
+	return buildAll(context.Background(), s, false, nil)
+}
+
+// BuildFile builds one source file of the site into w
+func (s *Site) BuildFile(path string, w io.Writer) error {
+	defer s.use()()
+	vars := pageGlobals(s.Vars)
+	pages = collectPages(vars)
+	resetMetas(vars)
+	if err := loadData(); err != nil {
+		return err
+	}
+	return build(path, w, vars)
+}
+
+// BuildMarkdown renders the Markdown page read from r into w, as if it was
+// a page in the root of the source directory
+func (s *Site) BuildMarkdown(r io.Reader, w io.Writer) error {
+	defer s.use()()
+	vars := pageGlobals(s.Vars)
+	pages = collectPages(vars)
+	resetMetas(vars)
+	if err := loadData(); err != nil {
+		return err
+	}
+	ext := ".md"
+	if len(mdExts) > 0 {
+		ext = mdExts[0]
+	}
+	return buildMarkdownReader(filepath.Join(s.SrcDir, "stdin"+ext), r, w, vars)
+}
+
+// Watch builds the site and then rebuilds the modified files until the
+// context is done. onChange (if any) is called after each build that changed
+// something. Other sites can be built while the site is watched.
+func (s *Site) Watch(ctx context.Context, onChange func()) {
+	buildAll(ctx, s, true, onChange)
+}
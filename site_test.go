@@ -0,0 +1,194 @@
+package z
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() { srcDir, pubDir = ".", PUBDIR }()
+
+	site := &Site{
+		SrcDir: filepath.Join(dir, "src"),
+		PubDir: filepath.Join(dir, "public"),
+		Vars:   Vars{"title": "Site"},
+	}
+	os.Mkdir(site.SrcDir, 0755)
+	ioutil.WriteFile(filepath.Join(site.SrcDir, "index.amber"), []byte("p #{title}"), 0644)
+
+	if err := site.Build(); err != nil {
+		t.Error(err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(site.PubDir, "index.html")); err != nil {
+		t.Error(err)
+	} else if string(b) != "<p>Site</p>\n" {
+		t.Error(string(b))
+	}
+
+	buf := &bytes.Buffer{}
+	if err := site.BuildFile(filepath.Join(site.SrcDir, "index.amber"), buf); err != nil {
+		t.Error(err)
+	} else if buf.String() != "<p>Site</p>\n" {
+		t.Error(buf.String())
+	}
+
+	// The initial build of the watcher publishes the removed page
+	os.Remove(filepath.Join(site.PubDir, "index.html"))
+	ctx, cancel := context.WithCancel(context.Background())
+	changed := make(chan bool, 10)
+	done := make(chan bool)
+	go func() {
+		site.Watch(ctx, func() { changed <- true })
+		close(done)
+	}()
+	<-changed
+	ioutil.WriteFile(filepath.Join(site.SrcDir, "about.amber"), []byte("p About"), 0644)
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Error("no rebuild after a file change")
+	}
+	if _, err := os.Stat(filepath.Join(site.PubDir, "about.html")); err != nil {
+		t.Error(err)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("watch did not stop")
+	}
+}
+
+func TestSiteBuildMarkdown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() { srcDir, pubDir = ".", PUBDIR }()
+
+	site := &Site{SrcDir: dir, PubDir: filepath.Join(dir, PUBDIR), Vars: Vars{"site": "Site"}}
+	os.Mkdir(filepath.Join(dir, ZSDIR), 0755)
+	ioutil.WriteFile(filepath.Join(dir, ZSDIR, "layout.amber"), []byte("div #{site} #{title}\n\t#{unescaped(content)}"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, ZSDIR, "plain.amber"), []byte("#{unescaped(content)}"), 0644)
+
+	buf := &bytes.Buffer{}
+	if err := site.BuildMarkdown(strings.NewReader("title: Piped\n---\n# Hello"), buf); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "<div>Site Piped<h1 id=\"hello\">Hello</h1>\n</div>\n"; buf.String() != expected {
+		t.Error(buf.String())
+	}
+
+	// stdin is read by "build -"
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+	w.WriteString("layout: plain.amber\n---\nHello *world*")
+	w.Close()
+	out := filepath.Join(dir, "out.html")
+	if err := buildOutput(site, "-", out); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(out); err != nil || string(b) != "<p>Hello <em>world</em></p>\n\n" {
+		t.Error(string(b), err)
+	}
+	if _, err := os.Stat(site.PubDir); err == nil {
+		t.Error("site built")
+	}
+}
+
+func TestSiteBuildErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() { srcDir, pubDir = ".", PUBDIR }()
+
+	site := &Site{SrcDir: filepath.Join(dir, "src"), PubDir: filepath.Join(dir, "public"), Vars: Vars{}}
+	os.Mkdir(site.SrcDir, 0755)
+	ioutil.WriteFile(filepath.Join(site.SrcDir, "bad.amber"), []byte("div\n\tp #{foo(}\n"), 0644)
+	ioutil.WriteFile(filepath.Join(site.SrcDir, "broken.md"), []byte("title: [\n---\nText\n"), 0644)
+	ioutil.WriteFile(filepath.Join(site.SrcDir, "good.amber"), []byte("p Good"), 0644)
+
+	err = site.Build()
+	if errs, ok := err.(errorList); !ok || len(errs) != 2 {
+		t.Fatal(err)
+	}
+	if !strings.Contains(err.Error(), "bad.amber") || !strings.Contains(err.Error(), "broken.md") {
+		t.Error(err)
+	}
+	if _, err := os.Stat(filepath.Join(site.PubDir, "good.html")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSites(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() { srcDir, pubDir = ".", PUBDIR }()
+
+	a := &Site{SrcDir: filepath.Join(dir, "a"), PubDir: filepath.Join(dir, "a.pub"), Vars: Vars{"title": "A", "fingerprint": "1"}}
+	b := &Site{SrcDir: filepath.Join(dir, "b"), PubDir: filepath.Join(dir, "b.pub"), Vars: Vars{"title": "B", "fingerprint": "1"}, Drafts: true}
+	for _, site := range []*Site{a, b} {
+		os.Mkdir(site.SrcDir, 0755)
+		ioutil.WriteFile(filepath.Join(site.SrcDir, "index.amber"), []byte("p #{title}\n"), 0644)
+	}
+	ioutil.WriteFile(filepath.Join(a.SrcDir, "style.css"), []byte("p{}"), 0644)
+	ioutil.WriteFile(filepath.Join(b.SrcDir, "style.css"), []byte("a{}"), 0644)
+	ioutil.WriteFile(filepath.Join(b.SrcDir, "draft.amber"), []byte("draft: true\n---\np Draft\n"), 0644)
+
+	// The site is watched while the other one is built
+	ctx, cancel := context.WithCancel(context.Background())
+	changed := make(chan bool, 10)
+	done := make(chan bool)
+	go func() {
+		a.Watch(ctx, func() { changed <- true })
+		close(done)
+	}()
+	<-changed
+	if err := b.Build(); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(a.SrcDir, "page.amber"), []byte("link[href=asset(\"/style.css\")]\n"), 0644)
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Error("no rebuild after a file change")
+	}
+	cancel()
+	<-done
+
+	for path, expected := range map[string]string{
+		filepath.Join(a.PubDir, "index.html"): "<p>A</p>\n",
+		filepath.Join(a.PubDir, "page.html"):  "<link href=\"/style.806db221.css\" />\n",
+		filepath.Join(b.PubDir, "index.html"): "<p>B</p>\n",
+		filepath.Join(b.PubDir, "draft.html"): "<p>Draft</p>\n",
+	} {
+		if b, err := ioutil.ReadFile(path); err != nil || string(b) != expected {
+			t.Error(path, string(b), err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(b.PubDir, "page.html")); err == nil {
+		t.Error("file of the other site built")
+	}
+}
@@ -0,0 +1,63 @@
+package z
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type urlset struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// buildSitemap writes sitemap.xml with all the generated HTML pages into the
+// output directory. Page urls are made absolute using the global url, if it's
+// not set - no sitemap is written. Pages with "sitemap: false" are excluded.
+func buildSitemap(vars Vars) error {
+	if vars["url"] == "" {
+		return nil
+	}
+	globals := pageGlobals(vars)
+	sitemap := urlset{Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+	err := walkSources(func(path string, info os.FileInfo) error {
+		url := outputPath(relPath(path), globals)
+		if filepath.Ext(url) != ".html" {
+			return nil
+		}
+		if isPage(path) || filepath.Ext(path) == ".html" {
+			v, _, err := getVars(path, globals)
+			if err != nil || v["sitemap"] == "false" || isDraft(v) || isScheduled(v) || isNotFound(path) {
+				return nil
+			}
+			url = v["url"]
+		}
+		sitemap.URLs = append(sitemap.URLs, sitemapURL{
+			Loc:     absURL(vars["url"], filepath.ToSlash(url)),
+			LastMod: info.ModTime().Format(time.RFC3339),
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(filepath.Join(pubDir, "sitemap.xml"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(f)
+	enc.Indent("", "\t")
+	return enc.Encode(sitemap)
+}
@@ -0,0 +1,45 @@
+package z
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSitemap(t *testing.T) {
+	defer chdirTemp(t)()
+
+	os.Mkdir(PUBDIR, 0755)
+	files := map[string]string{
+		"a.md":        "title: A\n---\nA",
+		"b.md":        "sitemap: false\n---\nB",
+		"c.html":      "<p>C</p>",
+		"index.amber": "p Index",
+		"styles.gcss": "body\n  margin: 0",
+	}
+	for name, content := range files {
+		ioutil.WriteFile(name, []byte(content), 0644)
+	}
+
+	if err := buildSitemap(Vars{}); err != nil {
+		t.Error(err)
+	} else if _, err := os.Stat(filepath.Join(PUBDIR, "sitemap.xml")); err == nil {
+		t.Error("sitemap written without site url")
+	}
+
+	if err := buildSitemap(Vars{"url": "http://example.com/"}); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadFile(filepath.Join(PUBDIR, "sitemap.xml"))
+	s := string(b)
+	for _, loc := range []string{"http://example.com/a.html", "http://example.com/c.html", "http://example.com/index.html"} {
+		if !strings.Contains(s, "<loc>"+loc+"</loc>") {
+			t.Error(loc, s)
+		}
+	}
+	if strings.Contains(s, "b.html") || strings.Contains(s, "styles") {
+		t.Error(s)
+	}
+}
@@ -0,0 +1,53 @@
+package z
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// followSymlinks is true if the source walk follows symbolic links, set with
+// ZS_FOLLOW_SYMLINKS=1. Otherwise symbolic links are skipped.
+var followSymlinks bool
+
+// walkTree is like filepath.Walk, but it skips symbolic links or follows them
+// if followSymlinks is set. Files under a followed link are reported under the
+// link path. Directories that have already been walked are skipped, so
+// symbolic link loops don't hang the walk.
+func walkTree(root string, fn filepath.WalkFunc) error {
+	visited := map[string]bool{}
+	if real, err := filepath.EvalSymlinks(root); err == nil {
+		visited[real] = true
+	}
+	var walkDir func(dir, name string) error
+	walkDir = func(dir, name string) error {
+		return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			src := path
+			if dir != name {
+				if rel, e := filepath.Rel(dir, path); e == nil {
+					path = filepath.Join(name, rel)
+				}
+			}
+			if err != nil || info.Mode()&os.ModeSymlink == 0 {
+				return fn(path, info, err)
+			}
+			if !followSymlinks {
+				logDebug("skip symlink:", path)
+				return nil
+			}
+			real, err := filepath.EvalSymlinks(src)
+			if err == nil {
+				info, err = os.Stat(real)
+			}
+			if err != nil || !info.IsDir() {
+				return fn(path, info, err)
+			}
+			if visited[real] {
+				logWarn("symlink loop:", path)
+				return nil
+			}
+			visited[real] = true
+			return walkDir(real, path)
+		})
+	}
+	return walkDir(root, root)
+}
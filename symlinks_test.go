@@ -0,0 +1,52 @@
+package z
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSymlinks(t *testing.T) {
+	defer chdirTemp(t)()
+	defer func() { followSymlinks = false }()
+
+	os.MkdirAll("site", 0755)
+	os.MkdirAll("shared/css", 0755)
+	ioutil.WriteFile("site/index.md", []byte("Hello\n"), 0644)
+	ioutil.WriteFile("shared/css/a.css", []byte("a{}"), 0644)
+	ioutil.WriteFile("shared/logo.png", []byte{}, 0644)
+	if err := os.Symlink("../shared", "site/static"); err != nil {
+		t.Skip(err)
+	}
+	os.Symlink("../shared/logo.png", "site/logo.png")
+	os.Symlink("..", "shared/css/loop")
+
+	srcDir = "site"
+	defer func() { srcDir = "." }()
+	if _, paths := walkAll(time.Unix(0, 0)); !reflect.DeepEqual(paths, []string{"site/index.md"}) {
+		t.Error(paths)
+	}
+
+	followSymlinks = true
+	done := make(chan []string)
+	go func() {
+		dirs, paths := walkAll(time.Unix(0, 0))
+		mirrorDirs(dirs)
+		done <- paths
+	}()
+	select {
+	case paths := <-done:
+		expected := []string{"site/index.md", "site/logo.png", "site/static/css/a.css", "site/static/logo.png"}
+		if !reflect.DeepEqual(paths, expected) {
+			t.Error(paths)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("symlink loop")
+	}
+	if _, err := os.Stat(filepath.Join(PUBDIR, "static", "css")); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,91 @@
+package z
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Directory of the generated tag pages, relative to the output directory
+const tagsDir = "tags"
+
+// tagged maps tag slugs to the pages having that tag. It is set on every
+// build together with pages.
+var tagged = map[string][]Vars{}
+
+// tagIndex builds an inverted index of the comma-separated "tags" variable
+// of the given pages. Tags are grouped by their slugs, names are taken from
+// the first page having the tag. Pages keep their order.
+func tagIndex(pages []Vars) (index map[string][]Vars, names map[string]string) {
+	index, names = map[string][]Vars{}, map[string]string{}
+	for _, v := range pages {
+		seen := map[string]bool{}
+		for _, tag := range strings.Split(v["tags"], ",") {
+			tag = strings.TrimSpace(tag)
+			slug := slugify(tag)
+			if slug == "" || seen[slug] {
+				continue
+			}
+			seen[slug] = true
+			if _, ok := names[slug]; !ok {
+				names[slug] = tag
+			}
+			index[slug] = append(index[slug], v)
+		}
+	}
+	return index, names
+}
+
+// tagURL returns the url of the tag page with the given slug
+func tagURL(slug string) string {
+	return tagsDir + "/" + slug + ".html"
+}
+
+// tagOutputs returns the tag pages built for the given pages (relative to the
+// output directory), if there is a tag layout
+func tagOutputs(pages []Vars) []string {
+	if _, err := os.Stat(zsPath("tag.amber")); err != nil {
+		return nil
+	}
+	_, names := tagIndex(pages)
+	outputs := []string{}
+	for slug := range names {
+		outputs = append(outputs, filepath.FromSlash(tagURL(slug)))
+	}
+	return outputs
+}
+
+// buildTags renders one page per tag into the tags directory using the
+// .zs/tag.amber layout. The layout gets the tag name as "tag" variable and
+// the list of matching pages as "tagged". If there is no tag layout - no tag
+// pages are built.
+func buildTags(vars Vars, pages []Vars) error {
+	layout := zsPath("tag.amber")
+	if _, err := os.Stat(layout); err != nil {
+		return nil
+	}
+	index, names := tagIndex(pages)
+	tagged = index
+	if len(index) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Join(pubDir, tagsDir), 0755); err != nil {
+		return err
+	}
+	for slug, name := range names {
+		v := pageGlobals(vars)
+		v["tag"] = name
+		v["title"] = name
+		v["url"] = tagURL(slug)
+		f, err := os.Create(filepath.Join(pubDir, filepath.FromSlash(v["url"])))
+		if err != nil {
+			return err
+		}
+		err = renderAmber(layout, f, v, nil)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
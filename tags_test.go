@@ -0,0 +1,66 @@
+package z
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTags(t *testing.T) {
+	defer chdirTemp(t)()
+	defer func() { tagged = map[string][]Vars{} }()
+
+	os.Mkdir(PUBDIR, 0755)
+	pages := []Vars{
+		{"title": "A", "url": "a.html", "tags": "go, Web Dev"},
+		{"title": "B", "url": "b.html", "tags": "go,go"},
+		{"title": "C", "url": "c.html"},
+	}
+	if err := buildTags(Vars{}, pages); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(PUBDIR, tagsDir)); err == nil {
+		t.Error("tags built without tag layout")
+	}
+
+	os.Mkdir(ZSDIR, 0755)
+	ioutil.WriteFile(filepath.Join(ZSDIR, "tag.amber"), []byte("h1 #{tag}\neach $p in tagged\n\ta[href=$p.url] #{$p.title}\n"), 0644)
+	if err := buildTags(Vars{}, pages); err != nil {
+		t.Fatal(err)
+	}
+	for path, expected := range map[string]string{
+		"go.html":      "<h1>go</h1>\n<a href=\"a.html\">A</a>\n<a href=\"b.html\">B</a>\n",
+		"web-dev.html": "<h1>Web Dev</h1>\n<a href=\"a.html\">A</a>\n",
+	} {
+		b, err := ioutil.ReadFile(filepath.Join(PUBDIR, tagsDir, path))
+		if err != nil {
+			t.Error(err)
+		} else if string(b) != expected {
+			t.Error(path, string(b))
+		}
+	}
+
+	// Tags of the deleted or changed pages are stale
+	ioutil.WriteFile("a.md", []byte("tags: go\n---\nA"), 0644)
+	ioutil.WriteFile(filepath.Join(PUBDIR, tagsDir, "go.html.gz"), []byte{}, 0644)
+	if err := cleanStale(); err != nil {
+		t.Fatal(err)
+	}
+	for path, exists := range map[string]bool{
+		"go.html":      true,
+		"go.html.gz":   true,
+		"web-dev.html": false,
+	} {
+		if _, err := os.Stat(filepath.Join(PUBDIR, tagsDir, path)); (err == nil) != exists {
+			t.Error(path, exists, err)
+		}
+	}
+	os.Remove(filepath.Join(ZSDIR, "tag.amber"))
+	if err := cleanStale(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(PUBDIR, tagsDir)); err == nil {
+		t.Error("tags kept without tag layout")
+	}
+}
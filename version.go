@@ -0,0 +1,22 @@
+package z
+
+import "fmt"
+
+// Build metadata, set with e.g.
+// go build -ldflags "-X github.com/cjp/z.version=1.0.0 -X github.com/cjp/z.commit=abc123 -X github.com/cjp/z.date=2015-08-28" ./cmd/z
+var (
+	version = "dev"
+	commit  = "unknown"
+	date    = "unknown"
+)
+
+// versionString returns the version, commit and build date of the binary
+func versionString() string {
+	return fmt.Sprintf("zs %s (commit %s, built %s)", version, commit, date)
+}
+
+// generator returns the name and version of the tool for the "generator"
+// template variable
+func generator() string {
+	return "zs " + version
+}
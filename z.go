@@ -1,29 +1,107 @@
-package main
+// Package z is a minimal static site generator: Markdown, Amber, GCSS and HTML
+// files of the source directory are built into the output directory. Sites are
+// built with Site, the z command in cmd/z builds the site of the current
+// directory.
+package z
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"html/template"
 	"io"
 	"io/ioutil"
-	"log"
+	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/BurntSushi/toml"
 	"github.com/eknkc/amber"
-	"github.com/russross/blackfriday"
+	"github.com/fsnotify/fsnotify"
+	"github.com/tdewolff/minify"
+	"github.com/tdewolff/minify/css"
+	"github.com/tdewolff/minify/html"
 	"github.com/yosssi/gcss"
 	"gopkg.in/yaml.v2"
 )
 
 const (
-	ZSDIR  = ".zs"
-	PUBDIR = ".pub"
+	ZSDIR    = ".zs"
+	PUBDIR   = ".pub"
+	DEFAULTS = "_defaults.yaml"
+
+	// Default build environment
+	PRODUCTION = "production"
+
+	// Rendered body of the page given to its layout, and its deprecated alias
+	CONTENT      = "content"
+	CONTENTALIAS = "__content"
 )
 
 type Vars map[string]string
 
+// setContent sets the rendered body of the page under its canonical name and
+// the deprecated alias
+func setContent(v Vars, content string) {
+	v[CONTENT] = content
+	v[CONTENTALIAS] = content
+}
+
+// Main source and output directories, can be changed with ZS_SRCDIR and
+// ZS_PUBDIR
+var (
+	srcDir = "."
+	pubDir = PUBDIR
+)
+
+// Markdown file extensions, can be changed with ZS_MD_EXT
+var (
+	defaultMdExts = []string{".md", ".mkd", ".markdown"}
+	mdExts        = defaultMdExts
+)
+
+// Variables of all Markdown pages, collected before the pages are built so
+// that templates could list them
+var pages []Vars
+
+// pagesModTime is the last time the list of pages changed, see listModTime.
+// It is set on every build together with pages.
+var pagesModTime time.Time
+
+// Command line flags, they may be given anywhere after the command name
+var (
+	flags       = flag.NewFlagSet("zs", flag.ContinueOnError)
+	force       = flags.Bool("force", false, "rebuild files even if they are up to date")
+	stale       = flags.Bool("stale", false, "only remove outputs of deleted sources")
+	drafts      = flags.Bool("drafts", false, "build draft pages")
+	future      = flags.Bool("future", false, "build pages dated in the future")
+	dryRun      = flags.Bool("dry-run", false, "only report what would be done")
+	verbose     = flags.Bool("v", false, "log every built and skipped file")
+	quiet       = flags.Bool("quiet", false, "only log warnings and errors")
+	env         = flags.String("env", "", "build environment, overrides ZS_ENV")
+	serveOn     = flags.String("serve", "", "serve the watched site on the given address")
+	asJSON      = flags.Bool("json", false, "print the list of pages as JSON")
+	cleanCached = flags.Bool("cache", false, "only remove the cache of rendered pages")
+	watchIgnore = flags.String("watch-ignore", "", "comma-separated patterns of the files that don't trigger rebuilds")
+	profile     = flags.Bool("profile", false, "print the time spent in the build phases and the slowest files")
+	cpuprofile  = flags.String("cpuprofile", "", "write CPU profile to the file")
+	since       = flags.String("since", "", "only build files modified after the time (RFC 3339 or @unixtime) or the file")
+	output      = flags.String("o", "", "output file of a single built file, - for stdout")
+	cfg         = flags.String("config", filepath.Join(ZSDIR, "config.yaml"), "site config file")
+	srcs        = &stringList{}
+)
+
 // renameExt renames extension (if any) from oldext to newext
 // If oldext is an empty string - extension is extracted automatically.
 // If path has no extension - new extension is appended
@@ -38,255 +116,2200 @@ func renameExt(path, oldext, newext string) string {
 	}
 }
 
+// Transliterations of common accented letters used in slugs
+var slugReplacer = strings.NewReplacer(
+	"à", "a", "á", "a", "â", "a", "ã", "a", "ä", "a", "å", "a", "æ", "ae",
+	"ç", "c", "è", "e", "é", "e", "ê", "e", "ë", "e",
+	"ì", "i", "í", "i", "î", "i", "ï", "i", "ñ", "n",
+	"ò", "o", "ó", "o", "ô", "o", "õ", "o", "ö", "o", "ø", "o", "œ", "oe",
+	"ù", "u", "ú", "u", "û", "u", "ü", "u", "ý", "y", "ÿ", "y", "ß", "ss",
+)
+
+// slugify turns s into a lowercase ASCII string where every sequence of
+// non-alphanumeric characters is replaced with a hyphen, e.g. "Über uns!"
+// becomes "uber-uns"
+func slugify(s string) string {
+	s = slugReplacer.Replace(strings.ToLower(s))
+	slug := []rune{}
+	for _, r := range s {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			slug = append(slug, r)
+		} else if len(slug) > 0 && slug[len(slug)-1] != '-' {
+			slug = append(slug, '-')
+		}
+	}
+	return strings.TrimSuffix(string(slug), "-")
+}
+
+// pageURL returns the default url of the page built from the given source
+// file (relative to the source directory). If ZS_SLUGIFY is set to 1 - the
+// file name is slugified. If ZS_PRETTY_URLS is set to 1 - the url is a
+// directory, e.g. "blog/post/" for blog/post.md and "blog/" for blog/index.md.
+func pageURL(rel string, vars Vars) string {
+	url := rel[:len(rel)-len(filepath.Ext(rel))]
+	if vars["slugify"] == "1" {
+		url = filepath.Join(filepath.Dir(url), slugify(filepath.Base(url)))
+	}
+	// The 404 page keeps its name, that's where web servers look for it
+	if vars["pretty_urls"] != "1" || url == "404" {
+		return url + ".html"
+	}
+	if filepath.Base(url) == "index" {
+		url = filepath.Dir(url)
+	}
+	if url == "." {
+		return ""
+	}
+	return filepath.ToSlash(url) + "/"
+}
+
+// urlOutput returns the output file path (relative to the output directory)
+// of the page url. Directory urls are written as index.html.
+func urlOutput(url string) string {
+	if url == "" || strings.HasSuffix(url, "/") {
+		return filepath.Join(filepath.FromSlash(url), "index.html")
+	}
+	return url
+}
+
+// createOutput creates the output file (relative to the output directory)
+// together with its directory
+func createOutput(path string) (*os.File, error) {
+	path = filepath.Join(pubDir, path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return os.Create(path)
+}
+
 // globals returns list of global OS environment variables that start
-// with ZS_ prefix as Vars, so the values can be used inside templates
+// with ZS_ prefix as Vars, so the values can be used inside templates.
+// Variables from the config file (.zs/config.yaml by default) are added too,
+// environment takes precedence. Builds outside of the production environment
+// are never minified or fingerprinted.
 func globals() Vars {
-	vars := Vars{}
+	vars := config(*cfg)
 	for _, e := range os.Environ() {
 		pair := strings.Split(e, "=")
 		if strings.HasPrefix(pair[0], "ZS_") {
 			vars[strings.ToLower(pair[0][3:])] = pair[1]
 		}
 	}
+	if *env != "" {
+		vars["env"] = *env
+	}
+	if *watchIgnore != "" {
+		vars["watch_ignore"] = *watchIgnore
+	}
+	if vars["env"] == "" {
+		vars["env"] = PRODUCTION
+	}
+	if vars["generator"] == "" {
+		vars["generator"] = generator()
+	}
+	if !strings.HasSuffix(vars["baseurl"], "/") {
+		vars["baseurl"] += "/"
+	}
+	if isDev(vars) {
+		vars["minify"] = "0"
+		vars["fingerprint"] = "0"
+	}
+	return vars
+}
+
+// isDev returns true if the variables belong to a build outside of the
+// production environment, e.g. "zs build --env dev"
+func isDev(vars Vars) bool {
+	return vars["env"] != "" && vars["env"] != PRODUCTION
+}
+
+// config reads flat key/value pairs from the YAML file. Missing file is not
+// an error, invalid file or values are reported and skipped.
+func config(path string) Vars {
+	vars := Vars{}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return vars
+	}
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal(b, &values); err != nil {
+		logError(path + ": " + err.Error())
+		return vars
+	}
+	for key, value := range values {
+		switch value.(type) {
+		case map[interface{}]interface{}, []interface{}:
+			logError(path + ": " + key + " must be a string, not a map or list")
+		case nil:
+			vars[strings.ToLower(key)] = ""
+		default:
+			vars[strings.ToLower(key)] = fmt.Sprint(value)
+		}
+	}
 	return vars
 }
 
+// defaults caches variables from the defaults files by directory during a
+// build. Directories without a defaults file have nil variables.
+var defaults = struct {
+	sync.Mutex
+	dirs map[string]Vars
+}{dirs: map[string]Vars{}}
+
+// resetDefaults clears the defaults cache, so that the defaults files are
+// read again
+func resetDefaults() {
+	defaults.Lock()
+	defer defaults.Unlock()
+	defaults.dirs = map[string]Vars{}
+}
+
+// defaultFiles returns the existing defaults files that apply to the given
+// source file, i.e. the ones in its directory and its parent directories up
+// to its source directory. Farthest files go first.
+func defaultFiles(path string) []string {
+	files := []string{}
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, DEFAULTS)); err == nil {
+			files = append([]string{filepath.Join(dir, DEFAULTS)}, files...)
+		}
+		if rel, err := filepath.Rel(rootOf(path), dir); err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			return files
+		}
+	}
+}
+
+// dirDefaults returns the variables from the defaults files that apply to the
+// given source file, closer files take precedence
+func dirDefaults(path string) Vars {
+	v := Vars{}
+	for _, file := range defaultFiles(path) {
+		dir := filepath.Dir(file)
+		defaults.Lock()
+		vars, ok := defaults.dirs[dir]
+		if !ok {
+			vars = config(file)
+			defaults.dirs[dir] = vars
+		}
+		defaults.Unlock()
+		for name, value := range vars {
+			v[name] = value
+		}
+	}
+	return v
+}
+
 // getVars returns list of variables defined in a text file and actual file
-// content following the variables declaration. Header is separated from
-// content by an empty line. Header can be either YAML or JSON.
-// If no empty newline is found - file is treated as content-only.
+// content following the variables declaration.
+// If no header is found - file is treated as content-only. Raw assets with a
+// sidecar file get the variables of the sidecar file and no content.
 func getVars(path string, globals Vars) (Vars, string, error) {
+	if !isPage(path) && filepath.Ext(path) != ".html" {
+		if _, err := os.Stat(path + metaExt); err == nil {
+			v, err := fileVars(path, config(path+metaExt), "", globals)
+			return v, "", err
+		}
+	}
 	b, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, "", err
 	}
-	s := string(b)
+	return parseVars(path, string(b), globals)
+}
+
+// parseVars is like getVars, but file content is given as s
+func parseVars(path, s string, globals Vars) (Vars, string, error) {
+	vars, body, err := splitHeader(s)
+	if err != nil {
+		return nil, "", headerError(path, s, err)
+	}
+	v, err := fileVars(path, vars, body, globals)
+	if err != nil {
+		return nil, "", err
+	}
+	return v, body, nil
+}
 
+// fileVars returns the variables of the file from its header variables, the
+// defaults and the globals
+func fileVars(path string, vars Vars, body string, globals Vars) (Vars, error) {
 	// Pick some default values for content-dependent variables
 	v := Vars{}
-	title := strings.Replace(strings.Replace(path, "_", " ", -1), "-", " ", -1)
-	v["title"] = strings.ToTitle(title)
+	title := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	title = strings.Replace(strings.Replace(title, "_", " ", -1), "-", " ", -1)
+	v["title"] = strings.Title(title)
 	v["description"] = ""
 	v["file"] = path
-	v["url"] = path[:len(path)-len(filepath.Ext(path))] + ".html"
-	v["output"] = filepath.Join(PUBDIR, v["url"])
+	v["url"] = pageURL(relPath(path), globals)
+	if !isPage(path) && filepath.Ext(path) != ".html" {
+		// Assets are published under their own name
+		v["url"] = filepath.ToSlash(outputPath(relPath(path), globals))
+	}
+	v["output"] = filepath.Join(pubDir, urlOutput(v["url"]))
 
 	// Override default values with globals
 	for name, value := range globals {
 		v[name] = value
 	}
 
+	// Override globals with the rules matching the page path, then with the
+	// defaults of the page directory and its parents
+	expand := map[string]bool{}
+	if !hidden(path) {
+		for name, value := range ruleDefaults(path) {
+			v[name] = value
+			expand[name] = true
+		}
+		for name, value := range dirDefaults(path) {
+			v[name] = value
+			expand[name] = true
+		}
+	}
+
 	// Add layout if none is specified
 	if _, ok := v["layout"]; !ok {
-		if _, err := os.Stat(filepath.Join(ZSDIR, "layout.amber")); err == nil {
-			v["layout"] = "layout.amber"
-		} else {
-			v["layout"] = "layout.html"
+		v["layout"] = defaultLayout()
+	}
+
+	// Override default values + globals with the ones defines in the file
+	for key, value := range vars {
+		v[key] = value
+		expand[key] = true
+	}
+	// Markdown pages are described by their first paragraph, unless the
+	// description is given in the header (even if it's empty)
+	if isMarkdown(path) {
+		if _, ok := vars["description"]; !ok {
+			if s := summary(body); s != "" {
+				v["description"] = s
+			}
+		}
+	}
+	if strings.HasPrefix(v["url"], "./") {
+		v["url"] = v["url"][2:]
+	}
+	if v["permalink"] != "" {
+		url, err := permalink(path, v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		v["url"] = url
+		v["output"] = filepath.Join(pubDir, urlOutput(url))
+	}
+	// Relative path from the page back to the site root, e.g. "../" for
+	// blog/post.html, so that links work under any sub-path
+	if _, ok := vars["root"]; !ok {
+		v["root"] = strings.Repeat("../", strings.Count(filepath.ToSlash(v["url"]), "/"))
+	}
+	// Link to the page from any other page, when the site is served from the
+	// base url
+	if _, ok := vars["link"]; !ok {
+		base := v["baseurl"]
+		if !strings.HasSuffix(base, "/") {
+			base += "/"
 		}
+		v["link"] = base + filepath.ToSlash(v["url"])
 	}
+	return expandVars(v, expand), nil
+}
 
-	delim := "\n---\n"
-	if sep := strings.Index(s, delim); sep == -1 {
-		return v, s, nil
-	} else {
-		header := s[:sep]
-		body := s[sep+len(delim):]
+// varRefRe matches references to other variables in variable values, e.g.
+// "{{url}}"
+var varRefRe = regexp.MustCompile(`\{\{\s*([\w.-]+)\s*\}\}`)
+
+// maxExpandDepth limits how deep variable references are followed
+const maxExpandDepth = 10
+
+// expandVars replaces references to other variables in the given values of
+// v, e.g. "{{root}}img/banner.png". Only the values set by the page header and
+// the defaults are expanded, the other values (globals, or the content of the
+// page given to its layout) are referenced as is. References may refer to
+// expanded values with more references. Unknown variables and references in
+// a cycle are left as is.
+func expandVars(v Vars, names map[string]bool) Vars {
+	var expand func(name string, seen map[string]bool) string
+	expand = func(name string, seen map[string]bool) string {
+		if !names[name] || len(seen) >= maxExpandDepth {
+			return v[name]
+		}
+		seen[name] = true
+		defer delete(seen, name)
+		return varRefRe.ReplaceAllStringFunc(v[name], func(ref string) string {
+			other := varRefRe.FindStringSubmatch(ref)[1]
+			if _, ok := v[other]; !ok || seen[other] {
+				return ref
+			}
+			return expand(other, seen)
+		})
+	}
+	expanded := Vars{}
+	for name := range v {
+		expanded[name] = expand(name, map[string]bool{})
+	}
+	return expanded
+}
+
+// defaultLayout returns the layout of the pages that don't set one, unless
+// it's given by ZS_LAYOUT: layout.amber if there is one, layout.html otherwise
+func defaultLayout() string {
+	if _, err := os.Stat(zsPath("layout.amber")); err == nil {
+		return "layout.amber"
+	}
+	return "layout.html"
+}
+
+// hasLayout returns false if the page is explicitly rendered without a layout
+// with "layout: none" or an empty layout
+func hasLayout(layout string) bool {
+	return layout != "" && layout != "none"
+}
+
+// layouts returns the layouts the page is rendered into, the innermost first.
+// Markdown pages use their "layout" variable, other pages and the layouts
+// themselves declare their layout in the header.
+func layouts(path string, v Vars) []string {
+	chain := []string{}
+	layout := v["layout"]
+	if !isMarkdown(path) {
+		layout = headerLayout(path)
+	}
+	for hasLayout(layout) {
+		next := zsPath(layout)
+		for _, p := range append(chain, path) {
+			if p == next {
+				return chain
+			}
+		}
+		chain = append(chain, next)
+		layout = headerLayout(next)
+	}
+	return chain
+}
 
-		vars := Vars{}
-		if err := yaml.Unmarshal([]byte(header), &vars); err != nil {
-			fmt.Println("ERROR: failed to parse header", err)
+// headerLayout returns the layout declared in the header of the file, if any
+func headerLayout(path string) string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	header, _, err := splitHeader(string(b))
+	if err != nil {
+		return ""
+	}
+	return header["layout"]
+}
+
+// isNotFound returns true if the source file is the 404 page of the site,
+// e.g. 404.md in the source directory. It's always published, so that web
+// servers could show it for missing pages.
+func isNotFound(path string) bool {
+	rel := filepath.ToSlash(relPath(path))
+	return path != "" && strings.TrimSuffix(rel, filepath.Ext(rel)) == "404"
+}
+
+// splitHeader returns variables declared in the header of s and the content
+// following the header. Header is separated from content by a "---" line.
+// Header can be either YAML or JSON. A TOML header can be used instead if
+// it's surrounded by "+++" lines. A JSON header may also go without a
+// separator if the file starts with "{".
+func splitHeader(s string) (Vars, string, error) {
+	vars := Vars{}
+	if strings.HasPrefix(s, "{") {
+		if header, body, ok := splitJSON(s); ok {
+			for key, value := range header {
+				flatten(vars, key, value)
+			}
+			return vars, body, nil
+		}
+	}
+	if strings.HasPrefix(s, "+++\n") {
+		delim := "\n+++\n"
+		sep := strings.Index(s[3:], delim)
+		if sep == -1 {
+			return vars, s, nil
+		}
+		header := map[string]interface{}{}
+		if _, err := toml.Decode(s[3:3+sep], &header); err != nil {
 			return nil, "", err
-		} else {
-			// Override default values + globals with the ones defines in the file
-			for key, value := range vars {
-				v[key] = value
+		}
+		for key, value := range header {
+			flatten(vars, key, value)
+		}
+		return vars, s[3+sep+len(delim):], nil
+	}
+
+	delim := "\n---\n"
+	sep := strings.Index(s, delim)
+	if sep == -1 || !headerRe.MatchString(s) {
+		return vars, s, nil
+	}
+	header := map[string]yamlValue{}
+	if err := yaml.Unmarshal([]byte(s[:sep]), &header); err != nil {
+		// Prose paragraphs followed by a horizontal rule are not a header
+		if strings.Contains(s[:sep], "\n\n") {
+			return Vars{}, s, nil
+		}
+		return nil, "", err
+	}
+	for key, value := range header {
+		flatten(vars, key, value.v)
+	}
+	return vars, s[sep+len(delim):], nil
+}
+
+// yamlValue is a YAML header value. Scalars are kept as written, e.g. "1.10"
+// or "2015-08-28", while lists and maps are flattened like in the other
+// header formats.
+type yamlValue struct {
+	v interface{}
+}
+
+func (y *yamlValue) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		y.v = s
+		return nil
+	}
+	return unmarshal(&y.v)
+}
+
+// splitJSON returns the JSON object s starts with and the content following
+// it, or false if s doesn't start with a valid JSON object
+func splitJSON(s string) (map[string]interface{}, string, bool) {
+	depth, inString, escaped := 0, false, false
+	for i, c := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth > 0 {
+				continue
 			}
+			header := map[string]interface{}{}
+			dec := json.NewDecoder(strings.NewReader(s[:i+1]))
+			dec.UseNumber()
+			if err := dec.Decode(&header); err != nil {
+				return nil, "", false
+			}
+			body := strings.TrimPrefix(s[i+1:], "\r")
+			body = strings.TrimPrefix(strings.TrimPrefix(body, "\n"), "---\n")
+			return header, body, true
+		}
+	}
+	return nil, "", false
+}
+
+// Line number in YAML and TOML errors
+var errLineRe = regexp.MustCompile(`line (\d+)`)
+
+// headerError describes the header parsing error of the file with its path
+// and, if the error refers to a line, the line number and text
+func headerError(path, s string, err error) error {
+	m := errLineRe.FindStringSubmatch(err.Error())
+	if m == nil {
+		return fmt.Errorf("%s: failed to parse header: %v", path, err)
+	}
+	n, _ := strconv.Atoi(m[1])
+	if _, ok := err.(*yaml.TypeError); !ok && strings.HasPrefix(err.Error(), "yaml:") {
+		// YAML library counts lines from zero, except in unmarshal errors
+		n++
+	}
+	lines := strings.Split(s, "\n")
+	if n < 1 || n > len(lines) {
+		return fmt.Errorf("%s:%d: failed to parse header: %v", path, n, err)
+	}
+	return fmt.Errorf("%s:%d: failed to parse header: %v: %q", path, n, err, lines[n-1])
+}
+
+// Message and line number in amber errors
+var amberErrRe = regexp.MustCompile(`^Amber Error: (.*) - Line: (\d+), Column: \d+`)
+
+// templateError describes the amber compile error of the file with its path
+// and, if the error refers to a line, the line number and text. Lines of the
+// template body are counted after the header of file content s.
+func templateError(path, s, body string, err error) error {
+	m := amberErrRe.FindStringSubmatch(err.Error())
+	if m == nil {
+		return fmt.Errorf("%s: failed to compile template: %v", path, err)
+	}
+	n, _ := strconv.Atoi(m[2])
+	lines := strings.Split(body, "\n")
+	if n < 1 || n > len(lines) {
+		return fmt.Errorf("%s: failed to compile template: %s", path, m[1])
+	}
+	line := lines[n-1]
+	if strings.HasSuffix(s, body) {
+		n += strings.Count(s[:len(s)-len(body)], "\n")
+	}
+	return fmt.Errorf("%s:%d: failed to compile template: %s: %q", path, n, m[1], line)
+}
+
+// YAML header starts with a "key:" line
+var headerRe = regexp.MustCompile(`^\s*[\w.-]+\s*:`)
+
+// flatten stores a structured header value in vars as a string. Nested tables
+// are stored using dotted keys, lists are joined with commas.
+func flatten(vars Vars, key string, value interface{}) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for k, x := range value {
+			flatten(vars, key+"."+k, x)
+		}
+	case map[interface{}]interface{}:
+		for k, x := range value {
+			flatten(vars, key+"."+fmt.Sprint(k), x)
+		}
+	case []interface{}:
+		items := []string{}
+		for _, x := range value {
+			items = append(items, fmt.Sprint(x))
 		}
-		if strings.HasPrefix(v["url"], "./") {
-			v["url"] = v["url"][2:]
+		vars[key] = strings.Join(items, ", ")
+	case time.Time:
+		vars[key] = value.Format(time.RFC3339)
+	case nil:
+		vars[key] = ""
+	default:
+		vars[key] = fmt.Sprint(value)
+	}
+}
+
+// isDraft returns true if the page is a draft that should not be published.
+// Drafts are built anyway if --drafts flag is given or outside of production.
+func isDraft(vars Vars) bool {
+	return vars["draft"] == "true" && !*drafts && !isDev(vars) && !isNotFound(vars["file"])
+}
+
+// isScheduled returns true if the page is dated in the future. Such pages are
+// built anyway if --future flag is given.
+func isScheduled(vars Vars) bool {
+	if *future || vars["date"] == "" || isNotFound(vars["file"]) {
+		return false
+	}
+	date, err := parseDate(vars["date"])
+	return err == nil && date.After(time.Now())
+}
+
+// Scheduled pages and their dates, so that watch mode could build them once
+// their date comes
+var scheduled = struct {
+	sync.Mutex
+	pages map[string]time.Time
+}{pages: map[string]time.Time{}}
+
+// holdBack returns true if the page must not be published yet, because it's
+// a draft or it's scheduled for a future date
+func holdBack(path string, vars Vars) bool {
+	if isDraft(vars) {
+		logInfo("draft:", path)
+		return true
+	}
+	if isScheduled(vars) {
+		logInfo("scheduled:", path, "("+vars["date"]+")")
+		date, _ := parseDate(vars["date"])
+		scheduled.Lock()
+		scheduled.pages[path] = date
+		scheduled.Unlock()
+		return true
+	}
+	return false
+}
+
+// due returns scheduled pages which date has come
+func due() []string {
+	scheduled.Lock()
+	defer scheduled.Unlock()
+	paths := []string{}
+	for path, date := range scheduled.pages {
+		if !date.After(time.Now()) {
+			paths = append(paths, path)
+			delete(scheduled.pages, path)
 		}
-		return v, body, nil
 	}
+	sort.Strings(paths)
+	return paths
 }
 
 // Renders markdown with the given layout into html expanding all the macros
 func buildMarkdown(path string, w io.Writer, vars Vars) error {
-	v, body, err := getVars(path, vars)
+	f, err := os.Open(path)
 	if err != nil {
 		return err
 	}
-	v["content"] = string(blackfriday.MarkdownCommon([]byte(body)))
-	if w == nil {
-		out, err := os.Create(filepath.Join(PUBDIR, renameExt(path, "", ".html")))
-		if err != nil {
-			return err
-		}
-		defer out.Close()
-		w = out
-	}
-	return buildAmber(filepath.Join(ZSDIR, v["layout"]), w, v)
+	defer f.Close()
+	return buildMarkdownReader(path, f, w, vars)
 }
 
-// Renders .amber file into .html
-func buildAmber(path string, w io.Writer, vars Vars) error {
-	v, body, err := getVars(path, vars)
+// buildMarkdownReader is like buildMarkdown, but the page is read from r.
+// Path is only used for the default variables, it may not exist.
+func buildMarkdownReader(path string, r io.Reader, w io.Writer, vars Vars) error {
+	b, err := ioutil.ReadAll(r)
 	if err != nil {
 		return err
 	}
-	a := amber.New()
-	if err := a.Parse(body); err != nil {
-		fmt.Println(body)
+	v, body, err := parseVars(path, string(b), vars)
+	if err != nil {
 		return err
 	}
-
-	t, err := a.Compile()
+	if holdBack(path, v) {
+		if w == nil {
+			return unpublish(path, vars, v)
+		}
+		return nil
+	}
+	render := func(w io.Writer) error {
+		stop := track("markdown")
+		setContent(v, imageAttrs(markdown(body, v), filepath.Dir(path), v))
+		v["excerpt"] = excerpt(body, v[CONTENT], filepath.Dir(path), v)
+		stop()
+		if !hasLayout(v["layout"]) {
+			return writeHTML(w, bytes.NewBufferString(v[CONTENT]), v)
+		}
+		return buildAmber(zsPath(v["layout"]), w, v)
+	}
+	if w != nil {
+		return render(w)
+	}
+	if ok, err := claimOutput(pageOutput(path, vars, v), path, v); !ok {
+		return err
+	}
+	out, err := createOutput(pageOutput(path, vars, v))
 	if err != nil {
 		return err
 	}
-
-	htmlBuf := &bytes.Buffer{}
-	if err := t.Execute(htmlBuf, v); err != nil {
+	defer out.Close()
+	if err := buildAliases(v); err != nil {
+		return err
+	}
+	if hasFormat(v, TXT) {
+		if err := buildPlainText(txtOutput(pageOutput(path, vars, v)), body, v); err != nil {
+			return err
+		}
+	}
+	// Pages are rendered once for the same source, variables and layouts,
+	// unless the build is forced
+	key := cacheKey(b, v)
+	if c, ok := cached(key); ok && !*force {
+		logDebug("cached:", path)
+		_, err := out.Write(c)
+		return err
+	}
+	buf := &bytes.Buffer{}
+	if err := render(buf); err != nil {
 		return err
 	}
+	uncached.Lock()
+	skip := uncached.pages[path]
+	uncached.Unlock()
+	if skip {
+		logDebug("not cached:", path)
+	} else if err := storeCache(key, buf.Bytes()); err != nil {
+		logWarn("cache:", err)
+	}
+	_, err = out.Write(buf.Bytes())
+	return err
+}
 
+// Renders .amber file into .html. If the file header declares a layout - the
+// result is rendered into that layout as content.
+func buildAmber(path string, w io.Writer, vars Vars) error {
+	v, _, err := getVars(path, vars)
+	if err == nil && holdBack(path, v) {
+		if w == nil {
+			return unpublish(path, vars, v)
+		}
+		return nil
+	}
+	if size := pageSize(path, v); w == nil && err == nil && size > 0 {
+		return buildPaginated(path, vars, size)
+	}
 	if w == nil {
-		f, err := os.Create(filepath.Join(PUBDIR, renameExt(path, ".amber", ".html")))
+		if ok, err := claimOutput(pageOutput(path, vars, v), path, v); !ok {
+			return err
+		}
+		f, err := createOutput(pageOutput(path, vars, v))
 		if err != nil {
 			return err
 		}
 		defer f.Close()
 		w = f
+		if err := buildAliases(v); err != nil {
+			return err
+		}
 	}
-	_, err = io.WriteString(w, string(htmlBuf.Bytes()))
-	return err
+	return renderAmber(path, w, vars, nil)
 }
 
-// Compiles .gcss into .css
-func buildGCSS(path string, w io.Writer) error {
-	f, err := os.Open(path)
+// renderAmber renders .amber file and all its parent layouts. Chain is the
+// list of files that are already being rendered, used to detect cycles.
+func renderAmber(path string, w io.Writer, vars Vars, chain []string) error {
+	for _, p := range chain {
+		if p == path {
+			return fmt.Errorf("layout cycle: %s -> %s", strings.Join(chain, " -> "), path)
+		}
+	}
+	b, err := ioutil.ReadFile(path)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-
-	if w == nil {
-		s := strings.TrimSuffix(path, ".gcss") + ".css"
-		css, err := os.Create(filepath.Join(PUBDIR, s))
-		if err != nil {
-			return err
-		}
-		defer css.Close()
-		w = css
+	src := string(b)
+	header, _, err := splitHeader(src)
+	if err != nil {
+		return err
+	}
+	v, body, err := parseVars(path, src, vars)
+	if err != nil {
+		return err
+	}
+	stop := track("layout")
+	t, err := amberTemplate(body, v, 0)
+	if err != nil {
+		stop()
+		return templateError(path, src, body, err)
 	}
-	_, err = gcss.Compile(w, f)
-	return err
-}
 
-// Copies file as is from path to writer
-func buildRaw(path string, w io.Writer) error {
-	in, err := os.Open(path)
+	htmlBuf := &bytes.Buffer{}
+	err = t.Execute(htmlBuf, templateData(v))
+	stop()
 	if err != nil {
 		return err
 	}
-	defer in.Close()
-	if w == nil {
-		if out, err := os.Create(filepath.Join(PUBDIR, path)); err != nil {
+
+	if layout, ok := header["layout"]; ok && hasLayout(layout) {
+		setContent(v, htmlBuf.String())
+		return renderAmber(zsPath(layout), w, v, append(chain, path))
+	}
+	return writeHTML(w, htmlBuf, v)
+}
+
+// writeHTML writes the rendered HTML page, minified if "minify" variable is 1
+// and normalized if "normalize_output" variable is 1. Root-relative links get
+// the base url if "baseurl_rewrite" variable is 1. Missing title, description
+// and canonical link are added to the head if "auto_head" variable is 1.
+func writeHTML(w io.Writer, buf *bytes.Buffer, vars Vars) error {
+	defer track("write")()
+	if vars["baseurl_rewrite"] == "1" {
+		buf = bytes.NewBufferString(rewriteLinks(buf.String(), vars["baseurl"]))
+	}
+	if vars["auto_head"] == "1" {
+		buf = bytes.NewBuffer(autoHead(buf.Bytes(), vars))
+	}
+	if vars["minify"] == "1" {
+		min := &bytes.Buffer{}
+		if err := minifier.Minify("text/html", min, buf); err != nil {
 			return err
-		} else {
-			defer out.Close()
-			w = out
 		}
+		buf = min
 	}
-	_, err = io.Copy(w, in)
+	_, err := w.Write(normalize(buf.Bytes(), vars))
 	return err
 }
 
-func build(path string, w io.Writer, vars Vars) error {
-	ext := filepath.Ext(path)
-	if ext == ".md" || ext == ".mkd" {
-		return buildMarkdown(path, w, vars)
+// Root-relative href and src attributes, the first character of the path is
+// captured to tell them from protocol-relative ones
+var rootLinkRe = regexp.MustCompile(`(\s(?:href|src)=["']?)/([^/])`)
+
+// rewriteLinks prefixes the root-relative links in HTML with the base url,
+// e.g. "/style.css" becomes "/docs/style.css" for "/docs/" base url. Links
+// that already start with the base path are kept.
+func rewriteLinks(s, base string) string {
+	if base == "" || base == "/" {
+		return s
+	}
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	basePath := base
+	if u, err := url.Parse(base); err == nil {
+		basePath = u.Path
+	}
+	out := &strings.Builder{}
+	last := 0
+	for _, m := range rootLinkRe.FindAllStringSubmatchIndex(s, -1) {
+		// m[3] is the end of the attribute name, where the path starts
+		if strings.HasPrefix(s[m[3]:], basePath) {
+			continue
+		}
+		out.WriteString(s[last:m[3]])
+		out.WriteString(base)
+		last = m[3] + 1
+	}
+	out.WriteString(s[last:])
+	return out.String()
+}
+
+// normalize converts line endings of the text output to "\n" and makes it end
+// with exactly one newline, if "normalize_output" variable is 1
+func normalize(b []byte, vars Vars) []byte {
+	if vars["normalize_output"] != "1" {
+		return b
+	}
+	if bytes.Contains(b, []byte("\r\n")) {
+		b = bytes.Replace(b, []byte("\r\n"), []byte("\n"), -1)
+	}
+	return append(bytes.TrimRight(b, "\r\n"), '\n')
+}
+
+// templateData returns the data passed to templates: page variables, the
+// list of all pages (or the current part of it for paginated pages), the
+// content of the data files and, for tag pages, the list of pages having the tag
+func templateData(vars Vars) map[string]interface{} {
+	data := map[string]interface{}{}
+	for k, v := range vars {
+		data[k] = v
+	}
+	data["pages"] = paginate(pages, vars)
+	data["menu"] = menu()
+	data["data"] = siteData
+	if prev, next, ok := neighbours(vars); ok {
+		data["prev"], data["next"] = prev, next
+	}
+	if tag, ok := vars["tag"]; ok {
+		data["tagged"] = tagged[slugify(tag)]
+	}
+	return data
+}
+
+// minifier is used for HTML and CSS outputs if ZS_MINIFY is set to 1. Contents
+// of <pre> and <textarea> elements are kept intact.
+var minifier = minify.New()
+
+// Maximum nesting level of included partials
+const maxIncludeDepth = 10
+
+// amberTemplate compiles amber source into a template. The template can
+// include partials from ZSDIR using #{include("name")}, depth is the current
+// include nesting level.
+func amberTemplate(body string, vars Vars, depth int) (*template.Template, error) {
+	a := amber.New()
+	if err := a.Parse(body); err != nil {
+		return nil, err
+	}
+	t, err := a.Compile()
+	if err != nil {
+		return nil, err
+	}
+	return t.Funcs(pageFuncs(vars, depth)), nil
+}
+
+// pageFuncs returns the template functions bound to the page variables
+func pageFuncs(vars Vars, depth int) template.FuncMap {
+	return template.FuncMap{
+		"include": func(name string) template.HTML {
+			return include(name, vars, depth+1)
+		},
+		"toc": func(levels ...int) template.HTML {
+			return toc(vars[CONTENT], levels...)
+		},
+		"srcset": func(name string) string {
+			markUncached(vars["file"])
+			return srcset(name, vars)
+		},
+		"csv": func(name string, delimiter ...string) template.HTML {
+			markUncached(vars["file"])
+			return csvTable(name, delimiter...)
+		},
+		"gitlog": func(path, field string) string {
+			markUncached(vars["file"])
+			return gitlog(path, field)
+		},
+		"meta": func(name, field string) template.HTML {
+			// Variables of Markdown pages are a part of the cache key
+			if !isMarkdown(name) {
+				markUncached(vars["file"])
+			}
+			return meta(name, field)
+		},
+		"markdownify": func(text string) template.HTML {
+			return markdownify(text, vars)
+		},
+	}
+}
+
+// funcMap returns the template functions available to all the amber and HTML
+// templates of the site. Functions depending on the page are bound to its
+// variables by pageFuncs.
+func funcMap() template.FuncMap {
+	return template.FuncMap{
+		"include":     include,
+		"asset":       asset,
+		"toc":         toc,
+		"dateFormat":  dateFormat,
+		"meta":        meta,
+		"csv":         csvTable,
+		"gitlog":      gitlog,
+		"srcset":      srcset,
+		"truncate":    truncate,
+		"markdownify": markdownify,
+	}
+}
+
+// Renders .html file with a header as a template, the header is stripped from
+// the output. If the header declares a layout - the result is rendered into
+// that layout as content. Files without a header are copied as is.
+func buildHTML(path string, w io.Writer, vars Vars) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	src := string(b)
+	header, _, err := splitHeader(src)
+	if err != nil {
+		return err
+	}
+	if len(header) == 0 {
+		return buildRaw(path, w, vars)
+	}
+	v, body, err := parseVars(path, src, vars)
+	if err != nil {
+		return err
+	}
+	if holdBack(path, v) {
+		if w == nil {
+			return unpublish(path, vars, v)
+		}
+		return nil
+	}
+	t, err := template.New(path).Funcs(amber.FuncMap).Funcs(pageFuncs(v, 0)).Parse(body)
+	if err != nil {
+		return err
+	}
+	htmlBuf := &bytes.Buffer{}
+	if err := t.Execute(htmlBuf, templateData(v)); err != nil {
+		return err
+	}
+
+	if w == nil {
+		if ok, err := claimOutput(relPath(path), path, v); !ok {
+			return err
+		}
+		f, err := os.Create(filepath.Join(pubDir, relPath(path)))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+		if err := buildAliases(v); err != nil {
+			return err
+		}
+	}
+	if layout, ok := header["layout"]; ok && hasLayout(layout) {
+		setContent(v, htmlBuf.String())
+		return renderAmber(zsPath(layout), w, v, []string{path})
+	}
+	return writeHTML(w, htmlBuf, v)
+}
+
+// includePath returns the path of the included file. It's looked up in the
+// directory of the page first, then like other service files with zsPath.
+// Paths relative to the page can't go outside of its source directory.
+func includePath(name string, vars Vars) (string, error) {
+	file := vars["file"]
+	if file == "" || filepath.IsAbs(name) {
+		return zsPath(name), nil
+	}
+	path := filepath.Join(filepath.Dir(file), name)
+	rel, err := filepath.Rel(rootOf(file), path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.New("outside of the source directory")
+	}
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		return zsPath(name), nil
+	}
+	return path, nil
+}
+
+// include renders partial file from the page directory or ZSDIR with the given
+// variables. Amber partials are rendered as templates, other files are
+// included as is. Errors are returned as HTML comments, so that they can be
+// found in page source.
+func include(name string, vars Vars, depth int) template.HTML {
+	if depth > maxIncludeDepth {
+		return template.HTML("<!-- include " + name + ": too many nested includes -->")
+	}
+	path, err := includePath(name, vars)
+	if err != nil {
+		return template.HTML("<!-- include " + name + ": " + err.Error() + " -->")
+	}
+	if path != zsPath(name) {
+		markUncached(vars["file"])
+	}
+	if filepath.Ext(path) != ".amber" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return template.HTML("<!-- include " + name + ": " + err.Error() + " -->")
+		}
+		return template.HTML(b)
+	}
+	buf := &bytes.Buffer{}
+	v, body, err := getVars(path, vars)
+	if err == nil {
+		var t *template.Template
+		if t, err = amberTemplate(body, v, depth); err == nil {
+			err = t.Execute(buf, templateData(v))
+		}
+	}
+	if err != nil {
+		return template.HTML("<!-- include " + name + ": " + err.Error() + " -->")
+	}
+	return template.HTML(buf.String())
+}
+
+// Compiles .gcss into .css
+func buildGCSS(path string, w io.Writer, vars Vars) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	buf := &bytes.Buffer{}
+	if _, err := gcss.Compile(buf, f); err != nil {
+		return err
+	}
+	if vars["minify"] == "1" {
+		min := &bytes.Buffer{}
+		if err := minifier.Minify("text/css", min, buf); err != nil {
+			return err
+		}
+		buf = min
+	}
+	buf = bytes.NewBuffer(normalize(buf.Bytes(), vars))
+	if w == nil && fingerprinted(path, vars) {
+		return fingerprint(outputPath(relPath(path), vars), buf.Bytes())
+	}
+	if w == nil {
+		s := strings.TrimSuffix(relPath(path), ".gcss") + ".css"
+		if ok, err := claimOutput(s, path, vars); !ok {
+			return err
+		}
+		css, err := os.Create(filepath.Join(pubDir, s))
+		if err != nil {
+			return err
+		}
+		defer css.Close()
+		w = css
+	}
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// Copies file as is from path to writer. If the file is copied into the
+// output directory, its permissions (except for the owner write bit) and
+// modification time are preserved.
+func buildRaw(path string, w io.Writer, vars Vars) error {
+	if w == nil && fingerprinted(path, vars) {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return fingerprint(relPath(path), b)
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if w != nil {
+		_, err = io.Copy(w, in)
+		return err
+	}
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if ok, err := claimOutput(relPath(path), path, vars); !ok {
+		return err
+	}
+	outPath := filepath.Join(pubDir, relPath(path))
+	out, err := os.Create(outPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	// The output stays writable, so that read-only sources can be rebuilt
+	if err := os.Chmod(outPath, info.Mode().Perm()|0200); err != nil {
+		return err
+	}
+	return os.Chtimes(outPath, info.ModTime(), info.ModTime())
+}
+
+// outputPath returns the path of the file (relative to the output directory)
+// that is built from the given source file (relative to the source directory)
+func outputPath(path string, vars Vars) string {
+	if isPage(path) {
+		return urlOutput(pageURL(path, vars))
+	}
+	switch filepath.Ext(path) {
+	case ".gcss":
+		return renameExt(path, ".gcss", ".css")
+	default:
+		return path
+	}
+}
+
+// needsRebuild returns true if the output file is missing or older than its
+// source. Pages are also rebuilt if any of their layouts or any of the
+// fingerprinted assets is newer, pages listing other pages are rebuilt if the
+// list of pages has changed. Fingerprinted assets
+// are always rebuilt, as their output names are not known in advance.
+func needsRebuild(src, out string, vars Vars) bool {
+	if fingerprinted(src, vars) {
+		return true
+	}
+	deps := []string{src}
+	if isPage(src) {
+		deps = append(deps, defaultFiles(src)...)
+		deps = append(deps, dataFiles()...)
+		if _, err := os.Stat(zsPath(RULES)); err == nil {
+			deps = append(deps, zsPath(RULES))
+		}
+	}
+	lists := false
+	if isPage(src) || filepath.Ext(src) == ".html" {
+		v, _, err := getVars(src, vars)
+		if err != nil {
+			return true
+		}
+		deps = append(deps, layouts(src, v)...)
+		if isPage(src) && v["permalink"] != "" {
+			out = filepath.Join(pubDir, pageOutput(src, vars, v))
+		}
+		lists = listsPages(src, v)
+	}
+	info, err := os.Stat(out)
+	if err != nil {
+		return true
+	}
+	if lists && pagesModTime.After(info.ModTime()) {
+		return true
+	}
+	if filepath.Ext(out) == ".html" {
+		deps = append(deps, fingerprints()...)
+	}
+	for _, dep := range deps {
+		if d, err := os.Stat(dep); err != nil || d.ModTime().After(info.ModTime()) {
+			return true
+		}
+	}
+	return false
+}
+
+func build(path string, w io.Writer, vars Vars) error {
+	ext := filepath.Ext(path)
+	if isPage(path) {
+		if err := validatePage(path, vars); err != nil {
+			return err
+		}
+	}
+	if isMarkdown(path) {
+		return buildMarkdown(path, w, vars)
 	} else if ext == ".amber" {
 		return buildAmber(path, w, vars)
 	} else if ext == ".gcss" {
-		return buildGCSS(path, w)
+		return buildGCSS(path, w, vars)
+	} else if ext == ".html" {
+		return buildHTML(path, w, vars)
 	} else {
-		return buildRaw(path, w)
+		return buildRaw(path, w, vars)
+	}
+}
+
+// buildFiles builds the given files in parallel using one worker per CPU
+// and returns the number of files built and the errors of all the files that
+// failed to build
+func buildFiles(paths []string, vars Vars) (int, []error) {
+	built := 0
+	errs := []error{}
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	queue := make(chan string, runtime.NumCPU())
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range queue {
+				rel := sourceOutput(path, vars)
+				out := filepath.Join(pubDir, rel)
+				if !*force && !needsRebuild(path, out, vars) {
+					if *dryRun {
+						logInfo("skip:", path)
+					} else {
+						logDebug("skip:", path)
+					}
+					// Up to date outputs still count for collisions
+					if _, err := claimOutput(rel, path, vars); err != nil {
+						mu.Lock()
+						errs = append(errs, err)
+						mu.Unlock()
+					}
+					continue
+				}
+				var w io.Writer
+				if *dryRun {
+					// Render the file to find errors, but don't write it
+					logInfo("build:", path, "->", out)
+					w = ioutil.Discard
+				} else {
+					logDebug("build:", path)
+				}
+				fileStart := time.Now()
+				err := build(path, w, vars)
+				trackFile(path, time.Since(fileStart))
+				mu.Lock()
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s: %v", path, err))
+				} else {
+					built++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, path := range paths {
+		queue <- path
+	}
+	close(queue)
+	wg.Wait()
+	return built, errs
+}
+
+// isMarkdown returns true if the file has one of the Markdown extensions
+func isMarkdown(path string) bool {
+	ext := filepath.Ext(path)
+	for _, e := range mdExts {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// isPage returns true if the file is a Markdown or an Amber page
+func isPage(path string) bool {
+	return isMarkdown(path) || filepath.Ext(path) == ".amber"
+}
+
+// relPath returns path relative to its source directory
+func relPath(path string) string {
+	if rel, err := filepath.Rel(rootOf(path), path); err == nil {
+		return rel
+	}
+	return path
+}
+
+// hidden returns true if the file or directory is hidden, i.e. its name or
+// its path relative to the source directory starts with a dot. Defaults files
+// and asset sidecar files are hidden too, as they are never published, as well
+// as the files ignored by the .zsignore file. The source directory itself is
+// never hidden.
+func hidden(path string) bool {
+	rel := relPath(path)
+	if rel == "." {
+		return false
+	}
+	return filepath.Base(rel)[0] == '.' || strings.HasPrefix(rel, ".") || filepath.Base(rel) == DEFAULTS ||
+		strings.HasSuffix(rel, metaExt) || isIgnored(path)
+}
+
+// walk returns the list of source directories found under root and the list
+// of source files modified after the given time
+func walk(root string, since time.Time) (dirs []string, paths []string) {
+	walkTree(root, func(path string, info os.FileInfo, err error) error {
+		// ignore hidden files and directories
+		if hidden(path) {
+			if err == nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// inform user about fs walk errors, but continue iteration
+		if err != nil {
+			logError(err.Error())
+			return nil
+		}
+
+		if info.IsDir() {
+			dirs = append(dirs, path)
+		} else if info.ModTime().After(since) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	return dirs, paths
+}
+
+// mirrorDirs creates the output directories of the given source directories
+func mirrorDirs(dirs []string) {
+	if *dryRun {
+		return
+	}
+	for _, dir := range dirs {
+		os.Mkdir(filepath.Join(pubDir, relPath(dir)), 0755)
+	}
+}
+
+// pageGlobals returns globals that apply to pages. Global url is the site
+// address, it must not override page urls.
+func pageGlobals(vars Vars) Vars {
+	globals := Vars{}
+	for k, v := range vars {
+		if k != "url" {
+			globals[k] = v
+		}
+	}
+	return globals
+}
+
+// collectPages returns variables of all Markdown pages. Pages with a weight
+// go first, lightest first, then the pages with a date, newest first. Pages
+// without either go last, in path order.
+func collectPages(vars Vars) []Vars {
+	collected := []Vars{}
+	walkSources(func(path string, info os.FileInfo) error {
+		if !isMarkdown(path) {
+			// Raw assets are listed if they have a sidecar file
+			if v, ok := metaVars(path, vars); ok && !isDraft(v) && !isScheduled(v) {
+				collected = append(collected, v)
+			}
+			return nil
+		}
+		if v, _, err := getVars(path, vars); err == nil && !isDraft(v) && !isScheduled(v) {
+			collected = append(collected, v)
+		}
+		return nil
+	})
+	sort.SliceStable(collected, func(i, j int) bool {
+		if less, ok := weightLess(collected[i], collected[j]); ok {
+			return less
+		}
+		a, errA := parseDate(collected[i]["date"])
+		b, errB := parseDate(collected[j]["date"])
+		if errA != nil || errB != nil {
+			return errA == nil && errB != nil
+		}
+		return a.After(b)
+	})
+	return collected
+}
+
+// listVarRe matches the template variables depending on the list of pages
+var listVarRe = regexp.MustCompile(`\b(pages|menu|prev|next)\b`)
+
+// includeRe matches the names of the included partials
+var includeRe = regexp.MustCompile(`include\(?\s*"([^"]+)"`)
+
+// listsPages returns true if the page, any of its layouts or any of the
+// included amber partials refers to the list of pages: "pages", "menu",
+// "prev" or "next". Markdown content is not a template, so only its layouts
+// are checked.
+func listsPages(path string, v Vars) bool {
+	files := layouts(path, v)
+	if !isMarkdown(path) {
+		files = append(files, path)
+	}
+	seen := map[string]bool{}
+	for len(files) > 0 {
+		file := files[0]
+		files = files[1:]
+		if seen[file] {
+			continue
+		}
+		seen[file] = true
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		if listVarRe.Match(b) {
+			return true
+		}
+		for _, m := range includeRe.FindAllSubmatch(b, -1) {
+			if p, err := includePath(string(m[1]), v); err == nil && filepath.Ext(p) == ".amber" {
+				files = append(files, p)
+			}
+		}
+	}
+	return false
+}
+
+// listModTime returns the last time the list of pages changed: the newest
+// modification time of the Markdown pages, the sidecar files and the source
+// directories (that change when files are added or removed), or the date of
+// the newest listed page if it's later, e.g. for scheduled pages that came due
+func listModTime(listed []Vars) time.Time {
+	t := time.Time{}
+	newer := func(path string) {
+		if info, err := os.Stat(path); err == nil && info.ModTime().After(t) {
+			t = info.ModTime()
+		}
+	}
+	dirs, paths := walkAll(time.Unix(0, 0))
+	for _, dir := range dirs {
+		newer(dir)
 	}
+	for _, path := range paths {
+		if isMarkdown(path) {
+			newer(path)
+		} else {
+			newer(path + metaExt)
+		}
+	}
+	now := time.Now()
+	for _, v := range listed {
+		if date, err := parseDate(v["date"]); err == nil && date.After(t) && !date.After(now) {
+			t = date
+		}
+	}
+	return t
 }
 
-func buildAll(watch bool) {
-	lastModified := time.Unix(0, 0)
-	modified := false
+// rebuild builds the given files followed by the site-wide outputs like
+// feeds. The .zs/prebuild and .zs/postbuild hooks are run before and after
+// the build, postbuild only if at least one file has been built. If at least
+// one file has been built - onChange is called. All
+// the errors are logged and returned.
+func rebuild(paths []string, vars Vars, onChange func()) []error {
+	if len(paths) == 0 {
+		return nil
+	}
+	start := time.Now()
+	failed := []error{}
+	fail := func(err error) {
+		logError(err.Error())
+		failed = append(failed, err)
+	}
+	resetDefaults()
+	resetRules()
+	resetSchema()
+	resetIgnores()
+	resetWritten()
+	resetCache()
+	resetGitLogs()
+	if err := loadData(); err != nil {
+		fail(err)
+	}
+	bundleList, err := bundles()
+	if err != nil {
+		fail(err)
+	}
+	inputs := bundleInputs(bundleList)
+	// Files overridden by a later source directory are never built, neither
+	// are the files of the bundles
+	kept, rebundled := []string{}, false
+	for _, path := range paths {
+		if inputs[filepath.ToSlash(relPath(path))] {
+			rebundled = true
+		} else if !overridden(path) {
+			kept = append(kept, path)
+		}
+	}
+	paths = kept
+	pages = collectPages(pageGlobals(vars))
+	// All the pages are checked for rebuild if the list of pages changed, so
+	// that the pages listing them are rebuilt
+	listed := listModTime(pages)
+	relisted := !pagesModTime.IsZero() && listed.After(pagesModTime)
+	pagesModTime = listed
+	resetMetas(pageGlobals(vars))
+	if err := runHook("prebuild", vars); err != nil {
+		fail(fmt.Errorf("prebuild: %v", err))
+	}
+	if !*dryRun {
+		if err := buildBundles(bundleList, vars); err != nil {
+			fail(err)
+		}
+	}
+	// Fingerprinted assets are built first, so that pages can refer to them.
+	// Pages are then checked for rebuild as the asset names may have changed.
+	assetPaths, otherPaths := []string{}, []string{}
+	for _, path := range paths {
+		if fingerprinted(path, vars) {
+			assetPaths = append(assetPaths, path)
+		} else {
+			otherPaths = append(otherPaths, path)
+		}
+	}
+	built := 0
+	if len(assetPaths) > 0 || (rebundled && vars["fingerprint"] == "1") {
+		n, errs := buildFiles(assetPaths, pageGlobals(vars))
+		for _, err := range errs {
+			fail(err)
+		}
+		built += n
+		otherPaths = []string{}
+		_, all := walkAll(time.Unix(0, 0))
+		for _, path := range all {
+			if !fingerprinted(path, vars) {
+				otherPaths = append(otherPaths, path)
+			}
+		}
+	} else if relisted {
+		seen := map[string]bool{}
+		for _, path := range otherPaths {
+			seen[path] = true
+		}
+		_, all := walkAll(time.Unix(0, 0))
+		for _, path := range all {
+			if !seen[path] && (isPage(path) || filepath.Ext(path) == ".html") {
+				otherPaths = append(otherPaths, path)
+			}
+		}
+	}
+	reserveOutputs(otherPaths, pageGlobals(vars))
+	n, errs := buildFiles(otherPaths, pageGlobals(vars))
+	for _, err := range errs {
+		fail(err)
+	}
+	built += n
+	if !*dryRun {
+		if err := resizeImages(vars); err != nil {
+			fail(err)
+		}
+		if err := buildFeeds(vars, pages); err != nil {
+			fail(err)
+		}
+		if err := buildSitemap(vars); err != nil {
+			fail(err)
+		}
+		if err := buildTags(vars, pages); err != nil {
+			fail(err)
+		}
+		if err := buildManifest(vars); err != nil {
+			fail(err)
+		}
+		if err := precompress(vars); err != nil {
+			fail(err)
+		}
+	}
+	if built > 0 && !*dryRun {
+		logInfo(fmt.Sprintf("rebuilt %d files in %dms", built, time.Since(start)/time.Millisecond))
+	}
+	// Nothing is published if all the files are up to date
+	changed := built > 0 || rebundled
+	if changed {
+		if err := runHook("postbuild", vars); err != nil {
+			fail(fmt.Errorf("postbuild: %v", err))
+		}
+	}
+	if len(failed) > 0 {
+		logWarn(fmt.Sprintf("%d errors", len(failed)))
+	}
+	if *profile {
+		printProfile(time.Since(start))
+		resetProfile()
+	}
+	if changed && onChange != nil {
+		onChange()
+	}
+	return failed
+}
 
-	vars := globals()
+// runHook runs the hook script with the given name from ZSDIR, if there is
+// one. The hook gets the global variables as ZS_* environment variables, its
+// output goes to the console.
+func runHook(name string, vars Vars) error {
+	path := script(name, pathExts())
+	if path == "" || *dryRun {
+		return nil
+	}
+	defer track("hooks")()
+	logInfo("hook:", name)
+	logDebug("hook:", path)
+	cmd := exec.Command(path)
+	cmd.Env = os.Environ()
+	for k, v := range vars {
+		cmd.Env = append(cmd.Env, "ZS_"+strings.ToUpper(k)+"="+v)
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// script returns the path of the script with the given name (see zsPath), trying
+// the given extensions in order, or an empty string if there is no such script
+func script(name string, exts []string) string {
+	for _, ext := range exts {
+		path := zsPath(name + ext)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
+// pathExts returns the extensions of executable scripts. On Windows they are
+// taken from PATHEXT, other systems need no extension.
+func pathExts() []string {
+	exts := []string{""}
+	if runtime.GOOS == "windows" {
+		pathext := os.Getenv("PATHEXT")
+		if pathext == "" {
+			pathext = ".com;.exe;.bat;.cmd"
+		}
+		exts = append(exts, filepath.SplitList(strings.ToLower(pathext))...)
+	}
+	return exts
+}
+
+// prependPath adds the directory to the beginning of the PATH-like list
+func prependPath(dir, list string) string {
+	if list == "" {
+		return dir
+	}
+	return dir + string(os.PathListSeparator) + list
+}
+
+// Changes made within this time are rebuilt together
+const watchDelay = 250 * time.Millisecond
+
+// notify watches source directories of the site for file system events and
+// rebuilds modified files. Events are debounced, so that editors writing files
+// in several steps trigger only one build. It only returns if the file system
+// notifications can not be used.
+func notify(ctx context.Context, site *Site, dirs []string, onChange func()) error {
+	vars := site.Vars
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	for _, dir := range dirs {
+		if err := w.Add(dir); err != nil {
+			return err
+		}
+	}
+
+	pending, reignore := map[string]bool{}, false
+	var debounce <-chan time.Time
+
+	// queue adds the source of the event to the files waiting to be rebuilt
+	queue := func(e fsnotify.Event) {
+		path := filepath.Clean(e.Name)
+		if watchIgnored(path, vars) {
+			logDebug("watch: ignore", path)
+			return
+		}
+		if filepath.Base(path) == DEFAULTS {
+			// Defaults changed - check all the pages below for rebuild
+			_, paths := walk(filepath.Dir(path), time.Unix(0, 0))
+			for _, p := range paths {
+				pending[p] = true
+			}
+			debounce = time.After(watchDelay)
+			return
+		}
+		if strings.HasSuffix(path, metaExt) {
+			// Sidecar changed - rebuild its asset to collect the pages again
+			pending[strings.TrimSuffix(path, metaExt)] = true
+			debounce = time.After(watchDelay)
+			return
+		}
+		if path == filepath.Join(srcDir, IGNORE) {
+			// Ignore file changed - build all the files again and remove
+			// the outputs of the ones that are ignored now
+			reignore = true
+			debounce = time.After(watchDelay)
+			return
+		}
+		if hidden(path) {
+			return
+		}
+		if e.Op&(fsnotify.Create|fsnotify.Write) != 0 {
+			pending[path] = true
+			debounce = time.After(watchDelay)
+		}
+	}
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
 	for {
-		os.Mkdir(PUBDIR, 0755)
-		filepath.Walk(".", func(path string, info os.FileInfo, err error) error {
-			// ignore hidden files and directories
-			if filepath.Base(path)[0] == '.' || strings.HasPrefix(path, ".") {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-tick.C:
+			done := site.use()
+			rebuild(due(), vars, onChange)
+			done()
+		case e, ok := <-w.Events:
+			if !ok {
 				return nil
 			}
-			// inform user about fs walk errors, but continue iteration
-			if err != nil {
-				fmt.Println("error:", err)
+			done := site.use()
+			queue(e)
+			done()
+		case err, ok := <-w.Errors:
+			if !ok {
 				return nil
 			}
+			logError(err.Error())
+		case <-debounce:
+			done := site.use()
+			modified := map[string]bool{}
+			if reignore {
+				resetIgnores()
+				dirs, paths := walkAll(time.Unix(0, 0))
+				mirrorDirs(dirs)
+				for _, dir := range dirs {
+					w.Add(dir)
+				}
+				for _, p := range paths {
+					modified[p] = true
+				}
+				if !*dryRun {
+					if err := cleanStale(); err != nil {
+						logError(err.Error())
+					}
+				}
+			}
+			for path := range pending {
+				if info, err := os.Stat(path); err != nil {
+					continue
+				} else if info.IsDir() {
+					// New directory - watch it and build all its files
+					dirs, paths := walk(path, time.Unix(0, 0))
+					mirrorDirs(dirs)
+					for _, dir := range dirs {
+						w.Add(dir)
+					}
+					for _, p := range paths {
+						modified[p] = true
+					}
+				} else {
+					modified[path] = true
+				}
+			}
+			paths := []string{}
+			for path := range modified {
+				paths = append(paths, path)
+			}
+			sort.Strings(paths)
+			pending, reignore = map[string]bool{}, false
+			rebuild(paths, vars, onChange)
+			done()
+		}
+	}
+}
+
+// errorList is the list of errors of a build
+type errorList []error
+
+func (l errorList) Error() string {
+	s := []string{}
+	for _, err := range l {
+		s = append(s, err.Error())
+	}
+	return strings.Join(s, "\n")
+}
 
-			if info.IsDir() {
-				os.Mkdir(filepath.Join(PUBDIR, path), 0755)
+// buildAll builds every file of the site. In watch mode files are rebuilt as
+// they are modified and onChange (if any) is called after each build that
+// changed something. Errors of the first build are returned as errorList, in
+// watch mode they are only logged. The builders are only locked for the site
+// while it's being built, not while waiting for changes.
+func buildAll(ctx context.Context, site *Site, watch bool, onChange func()) error {
+	vars := site.Vars
+	done := site.use()
+	if !*dryRun {
+		os.Mkdir(pubDir, 0755)
+	}
+	t, err := parseSince(*since)
+	if err != nil {
+		done()
+		logError(err.Error())
+		return err
+	}
+	stop := track("walk")
+	dirs, paths := walkAll(t)
+	mirrorDirs(dirs)
+	stop()
+	errs := rebuild(paths, vars, onChange)
+	done()
+	if len(errs) > 0 && !watch {
+		return errorList(errs)
+	}
+	if !watch {
+		return nil
+	}
+
+	lastModified := time.Now()
+	if err := notify(ctx, site, dirs, onChange); err != nil {
+		logWarn(err.Error() + ", polling for changes instead")
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(1 * time.Second):
+		}
+		done := site.use()
+		now := time.Now()
+		os.Mkdir(pubDir, 0755)
+		dirs, modified := walkAll(lastModified)
+		mirrorDirs(dirs)
+		paths := due()
+		for _, path := range modified {
+			if !watchIgnored(path, vars) {
+				paths = append(paths, path)
+			}
+		}
+		rebuild(paths, vars, onChange)
+		lastModified = now
+		done()
+	}
+}
+
+// parseSince returns the time given with --since flag: RFC 3339 time, Unix
+// time like "@1440763200" or the path of a file to take its modification
+// time. Empty string means the beginning of Unix time.
+func parseSince(s string) (time.Time, error) {
+	if s == "" {
+		return time.Unix(0, 0), nil
+	}
+	if strings.HasPrefix(s, "@") {
+		n, err := strconv.ParseInt(s[1:], 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("since: invalid unix time %s", s)
+		}
+		return time.Unix(n, 0), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	info, err := os.Stat(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("since: %s is neither a time nor a file", s)
+	}
+	return info.ModTime(), nil
+}
+
+// Output files and directories generated from the whole site rather than from
+// a single source
+var generated = []string{"rss.xml", "atom.xml", "sitemap.xml", "manifest.json"}
+
+// sources returns the list of source files that could have produced the
+// given output path (relative to the output directory)
+func sources(path string) []string {
+	switch filepath.Ext(path) {
+	case ".gz":
+		return sources(strings.TrimSuffix(path, ".gz"))
+	case ".js":
+		return []string{path, unfingerprint(path)}
+	case ".html":
+		srcs := []string{path, renameExt(path, ".html", ".amber")}
+		for _, ext := range mdExts {
+			srcs = append(srcs, renameExt(path, ".html", ext))
+		}
+		return srcs
+	case ".css":
+		return []string{path,
+			unfingerprint(path),
+			renameExt(unfingerprint(path), ".css", ".gcss")}
+	default:
+		return []string{path}
+	}
+}
+
+// clean removes the whole output directory
+func clean() error {
+	if *dryRun {
+		logInfo("clean:", pubDir)
+		return nil
+	}
+	if err := os.RemoveAll(pubDir); err != nil {
+		return err
+	}
+	logInfo("clean:", pubDir)
+	return nil
+}
+
+// cleanStale removes files and directories from the output directory which
+// source has been renamed, deleted or ignored
+func cleanStale() error {
+	// Slugified page names, page aliases and bundles can't be mapped back to
+	// their sources, so such outputs are collected from the sources instead
+	expected := map[string]bool{}
+	vars := pageGlobals(globals())
+	list, err := bundles()
+	if err != nil {
+		return err
+	}
+	for name := range list {
+		for p := filepath.FromSlash(name); p != "."; p = filepath.Dir(p) {
+			expected[p] = true
+		}
+	}
+	// Paginated pages only have as many pages as needed to list all the pages,
+	// tag pages are only kept for the tags of the pages
+	listed := collectPages(vars)
+	for _, out := range tagOutputs(listed) {
+		for p := out; p != "."; p = filepath.Dir(p) {
+			expected[p] = true
+		}
+	}
+	_, paths := walkAll(time.Unix(0, 0))
+	for _, path := range paths {
+		outputs := []string{}
+		if resizable(relPath(path), vars) {
+			widths, _, _ := imageVariants(path, vars)
+			for _, width := range widths {
+				outputs = append(outputs, imageVariant(relPath(path), width))
+			}
+		}
+		if vars["slugify"] == "1" || vars["pretty_urls"] == "1" {
+			outputs = append(outputs, outputPath(relPath(path), vars))
+		}
+		if isPage(path) || filepath.Ext(path) == ".html" {
+			if v, _, err := getVars(path, vars); err == nil {
+				list, _ := aliases(v)
+				for _, alias := range list {
+					outputs = append(outputs, filepath.FromSlash(alias))
+				}
+				if v["permalink"] != "" {
+					outputs = append(outputs, filepath.FromSlash(urlOutput(v["url"])))
+				}
+				if isMarkdown(path) && hasFormat(v, TXT) {
+					outputs = append(outputs, txtOutput(pageOutput(path, vars, v)))
+				}
+				if size := pageSize(path, v); size > 0 {
+					url := pageURL(relPath(path), vars)
+					for n := 1; n <= pageCount(len(listed), size); n++ {
+						outputs = append(outputs, filepath.FromSlash(urlOutput(pageNumURL(url, n))))
+					}
+				}
+			}
+		}
+		for _, out := range outputs {
+			// Keep the output directories too
+			for p := out; p != "."; p = filepath.Dir(p) {
+				expected[p] = true
+			}
+		}
+	}
+	return filepath.Walk(pubDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(pubDir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		for _, g := range generated {
+			if rel == g || rel == g+".gz" {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
 				return nil
-			} else if info.ModTime().After(lastModified) {
-				if !modified {
-					// First file in this build cycle is about to be modified
-					// TODO: future prehook action
-					modified = true
+			}
+		}
+		if info.IsDir() {
+			if src := sourcePath(rel); (src == "" || isIgnored(src)) && !expected[rel] {
+				logInfo("clean:", path)
+				if *dryRun {
+					return filepath.SkipDir
 				}
-				log.Println("build:", path)
-				return build(path, nil, vars)
+				if err := os.RemoveAll(path); err != nil {
+					return err
+				}
+				return filepath.SkipDir
 			}
 			return nil
-		})
-		if modified {
-			// At least one file in this build cycle has been modified
-			// TODO: future posthook action
-			modified = false
 		}
-		if !watch {
-			break
+		if expected[rel] || expected[unfingerprint(rel)] || expected[strings.TrimSuffix(rel, ".gz")] {
+			return nil
 		}
-		lastModified = time.Now()
-		time.Sleep(1 * time.Second)
-	}
+		for _, src := range sources(rel) {
+			if p := sourcePath(src); p != "" && !isIgnored(p) {
+				return nil
+			}
+		}
+		logInfo("clean:", path)
+		if *dryRun {
+			return nil
+		}
+		return os.Remove(path)
+	})
 }
 
 func init() {
+	flags.Var(srcs, "src", "source directory, may be given several times")
+
+	// Register template functions, so amber recognizes them. The actual
+	// implementations are bound to page variables in amberTemplate.
+	for name, fn := range funcMap() {
+		amber.FuncMap[name] = fn
+	}
+
+	minifier.Add("text/html", &html.Minifier{KeepDocumentTags: true, KeepEndTags: true})
+	minifier.AddFunc("text/css", css.Minify)
+
 	// prepend .zs to $PATH, so plugins will be found before OS commands
-	p := os.Getenv("PATH")
-	p = ZSDIR + ":" + p
-	os.Setenv("PATH", p)
+	os.Setenv("PATH", prependPath(ZSDIR, os.Getenv("PATH")))
+}
+
+// buildOutput builds one source file into the given output file, or prints
+// it to stdout if the output is empty or "-". If the path is "-", Markdown is
+// read from stdin.
+func buildOutput(site *Site, path, out string) error {
+	build := func(w io.Writer) error {
+		if path == "-" {
+			return site.BuildMarkdown(os.Stdin, w)
+		}
+		return site.BuildFile(path, w)
+	}
+	if out == "" || out == "-" {
+		return build(os.Stdout)
+	}
+	if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	if err := build(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
 
-func main() {
+// parseFlags parses command line flags which may be mixed with positional
+// arguments and returns the positional arguments
+func parseFlags(args []string) ([]string, error) {
+	rest := []string{}
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return rest, nil
+		}
+		rest = append(rest, args[0])
+		args = args[1:]
+	}
+}
+
+// Main runs the command given in the command line arguments of the process,
+// e.g. "build" or "watch", and exits with status 1 if it fails
+func Main() {
 	if len(os.Args) == 1 {
 		fmt.Println(os.Args[0], "<command> [args]")
 		return
 	}
 	cmd := os.Args[1]
-	args := os.Args[2:]
+	args, err := parseFlags(os.Args[2:])
+	if err == flag.ErrHelp {
+		return
+	} else if err != nil {
+		exit(1)
+	}
+	site := NewSite()
+	setLogLevel(site.Vars)
+	if *cpuprofile != "" {
+		if err := startCPUProfile(*cpuprofile); err != nil {
+			logError(err.Error())
+			exit(1)
+		}
+		defer stopCPUProfile()
+	}
 	switch cmd {
 	case "build":
-		if len(args) == 0 {
-			buildAll(false)
+		if len(args) == 0 && *output != "" {
+			logError("-o can only be used when building one file")
+			exit(1)
+		} else if len(args) == 0 {
+			if err := site.Build(); err != nil {
+				exit(1)
+			}
 		} else if len(args) == 1 {
-			if err := build(args[0], os.Stdout, globals()); err != nil {
-				fmt.Println("ERROR: " + err.Error())
+			if err := buildOutput(site, args[0], *output); err != nil {
+				logError(err.Error())
+				exit(1)
 			}
 		} else {
-			fmt.Println("ERROR: too many arguments")
+			logError("too many arguments")
+			exit(1)
 		}
 	case "watch":
-		buildAll(true)
+		if *serveOn == "" {
+			site.Watch(context.Background(), nil)
+		} else if err := serve(site, *serveOn); err != nil {
+			logError(err.Error())
+			exit(1)
+		}
+	case "serve":
+		addr := ":8080"
+		if len(args) > 0 {
+			addr = args[0]
+		}
+		if err := serve(site, addr); err != nil {
+			logError(err.Error())
+			exit(1)
+		}
+	case "clean":
+		defer site.use()()
+		if len(args) > 0 {
+			err = fmt.Errorf("unexpected arguments: %s", strings.Join(args, " "))
+		} else if *stale {
+			err = cleanStale()
+		} else if *cleanCached {
+			err = cleanCache()
+		} else {
+			err = clean()
+		}
+		if err != nil {
+			logError(err.Error())
+			exit(1)
+		}
+	case "deploy":
+		defer site.use()()
+		target := site.Vars["deploy_target"]
+		if len(args) == 1 {
+			target = args[0]
+		}
+		if len(args) > 1 {
+			err = fmt.Errorf("unexpected arguments: %s", strings.Join(args[1:], " "))
+		} else {
+			err = deploy(target)
+		}
+		if err != nil {
+			logError(err.Error())
+			exit(1)
+		}
+	case "check":
+		defer site.use()()
+		problems := check(site.Vars)
+		for _, err := range problems {
+			logError(err.Error())
+		}
+		if len(problems) > 0 {
+			exit(1)
+		}
+	case "list":
+		defer site.use()()
+		if err := listPages(os.Stdout, pageGlobals(site.Vars), *asJSON); err != nil {
+			logError(err.Error())
+			exit(1)
+		}
+	case "version":
+		fmt.Println(versionString())
 	case "var":
+		defer site.use()()
 		if len(args) == 0 {
 			fmt.Println("var: filename expected")
 		} else {
@@ -306,5 +2329,8 @@ func main() {
 			}
 			fmt.Println(strings.TrimSpace(s))
 		}
+	default:
+		logError("unknown command: " + cmd)
+		exit(1)
 	}
 }
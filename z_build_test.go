@@ -1,4 +1,4 @@
-package main
+package z
 
 import (
 	"crypto/md5"
@@ -28,9 +28,10 @@ func testBuild(path string, t *testing.T) {
 	args := os.Args[:]
 	os.Args = []string{"zs", "build"}
 	t.Log("--- BUILD", path)
-	main()
+	Main()
 
 	compare(PUBDIR, TESTDIR, t)
+	os.RemoveAll(filepath.Join(ZSDIR, CACHEDIR))
 
 	os.Chdir(wd)
 	os.Args = args
@@ -1,11 +1,36 @@
-package main
+package z
 
 import (
+	"bytes"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
+// chdirTemp changes the working directory to a new temporary directory and
+// returns the function that changes it back and removes the directory
+func chdirTemp(t testing.TB) func() {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "zs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
 func TestRenameExt(t *testing.T) {
 	if s := renameExt("foo.amber", ".amber", ".html"); s != "foo.html" {
 		t.Error(s)
@@ -32,20 +57,108 @@ title: Hello, world!
 ---
 Some content in markdown
 `: Vars{
-			"foo":       "bar",
-			"title":     "Hello, world!",
-			"url":       "test.html",
-			"file":      "test.md",
-			"output":    filepath.Join(PUBDIR, "test.html"),
-			"__content": "Some content in markdown\n",
+			"foo":    "bar",
+			"title":  "Hello, world!",
+			"url":    "test.html",
+			"file":   "test.md",
+			"output": filepath.Join(PUBDIR, "test.html"),
+			CONTENT:  "Some content in markdown\n",
 		},
 		`
 url: "example.com/foo.html"
 ---
 Hello
 `: Vars{
-			"url":       "example.com/foo.html",
-			"__content": "Hello\n",
+			"url":   "example.com/foo.html",
+			CONTENT: "Hello\n",
+		},
+	}
+
+	for script, vars := range tests {
+		ioutil.WriteFile("test.md", []byte(script), 0644)
+		if v, s, err := getVars("test.md", Vars{"baz": "123"}); err != nil {
+			t.Error(err)
+		} else if s != vars[CONTENT] {
+			t.Error(s, vars[CONTENT])
+		} else {
+			for key, value := range vars {
+				if key != CONTENT && v[key] != value {
+					t.Error(key, v[key], value)
+				}
+			}
+		}
+	}
+}
+
+func TestNoHeader(t *testing.T) {
+	for _, s := range []string{
+		"Just some text\n",
+		"Note: colons in prose\nare fine.\n",
+		"# Title\n\nSee: the rule below\n---\nMore text\n",
+		"Note: colons in\na paragraph.\n\nMore text\n---\nAfter the rule\n",
+	} {
+		v, body, err := parseVars("test.md", s, Vars{})
+		if err != nil {
+			t.Error(s, err)
+		} else if body != s {
+			t.Error(s, body)
+		} else if v["title"] != "Test" || v["url"] != "test.html" {
+			t.Error(s, v)
+		}
+	}
+	if _, _, err := parseVars("test.md", "title: [broken\n---\nBody\n", Vars{}); err == nil {
+		t.Error("broken header accepted")
+	}
+}
+
+func TestRoot(t *testing.T) {
+	for path, root := range map[string]string{
+		"index.md":           "",
+		"blog/post.md":       "../",
+		"blog/2015/post.md":  "../../",
+		"blog/2015/index.md": "../../",
+	} {
+		if v, _, err := parseVars(path, "Hello\n", Vars{}); err != nil {
+			t.Error(err)
+		} else if v["root"] != root {
+			t.Error(path, v["root"], root)
+		}
+	}
+	if v, _, err := parseVars("blog/post.md", "url: post.html\n---\nHello\n", Vars{}); err != nil {
+		t.Error(err)
+	} else if v["root"] != "" {
+		t.Error(v["root"])
+	}
+}
+
+func TestTOMLVars(t *testing.T) {
+	tests := map[string]Vars{
+		`+++
+foo = "bar"
+title = "Hello, world!"
++++
+Some content in markdown
+`: Vars{
+			"foo":    "bar",
+			"title":  "Hello, world!",
+			"url":    "test.html",
+			"file":   "test.md",
+			"output": filepath.Join(PUBDIR, "test.html"),
+			CONTENT:  "Some content in markdown\n",
+		},
+		`+++
+url = "example.com/foo.html"
+tags = ["foo", "bar"]
+
+[author]
+name = "John"
++++
+Hello
+`: Vars{
+			"url":         "example.com/foo.html",
+			"tags":        "foo, bar",
+			"author.name": "John",
+			CONTENT:       "Hello\n",
 		},
 	}
 
@@ -53,14 +166,1239 @@ Hello
 		ioutil.WriteFile("test.md", []byte(script), 0644)
 		if v, s, err := getVars("test.md", Vars{"baz": "123"}); err != nil {
 			t.Error(err)
-		} else if s != vars["__content"] {
-			t.Error(s, vars["__content"])
+		} else if s != vars[CONTENT] {
+			t.Error(s, vars[CONTENT])
 		} else {
 			for key, value := range vars {
-				if key != "__content" && v[key] != value {
+				if key != CONTENT && v[key] != value {
 					t.Error(key, v[key], value)
 				}
 			}
 		}
 	}
 }
+
+func TestCleanStale(t *testing.T) {
+	defer chdirTemp(t)()
+
+	for _, path := range []string{"a.md", "b.gcss", "img/x.png",
+		PUBDIR + "/a.html", PUBDIR + "/b.css", PUBDIR + "/c.html", PUBDIR + "/rss.xml",
+		PUBDIR + "/img/x.png", PUBDIR + "/img/y.png", PUBDIR + "/old/z.html"} {
+		os.MkdirAll(filepath.Dir(path), 0755)
+		ioutil.WriteFile(path, []byte{}, 0644)
+	}
+	if err := cleanStale(); err != nil {
+		t.Fatal(err)
+	}
+	for path, exists := range map[string]bool{
+		"a.html":     true,
+		"b.css":      true,
+		"rss.xml":    true,
+		"img/x.png":  true,
+		"c.html":     false,
+		"img/y.png":  false,
+		"old":        false,
+		"old/z.html": false,
+	} {
+		if _, err := os.Stat(filepath.Join(PUBDIR, path)); (err == nil) != exists {
+			t.Error(path, exists, err)
+		}
+	}
+}
+
+func TestNeedsRebuild(t *testing.T) {
+	defer chdirTemp(t)()
+
+	os.Mkdir(ZSDIR, 0755)
+	for _, path := range []string{"a.md", "b.txt", filepath.Join(ZSDIR, "layout.html"), "a.html", "b.txt.out"} {
+		ioutil.WriteFile(path, []byte{}, 0644)
+	}
+	past := time.Now().Add(-time.Hour)
+	touch := func(path string, t time.Time) { os.Chtimes(path, t, t) }
+
+	touch("a.md", past)
+	touch(filepath.Join(ZSDIR, "layout.html"), past)
+	touch("b.txt", past)
+	if needsRebuild("a.md", "a.html", Vars{}) {
+		t.Error("output is newer than source and layout")
+	}
+	if needsRebuild("b.txt", "b.txt.out", Vars{}) {
+		t.Error("output is newer than source")
+	}
+	if !needsRebuild("b.txt", "missing.out", Vars{}) {
+		t.Error("output is missing")
+	}
+	touch("b.txt", time.Now().Add(time.Hour))
+	if !needsRebuild("b.txt", "b.txt.out", Vars{}) {
+		t.Error("source is newer than output")
+	}
+	touch(filepath.Join(ZSDIR, "layout.html"), time.Now().Add(time.Hour))
+	if !needsRebuild("a.md", "a.html", Vars{}) {
+		t.Error("layout is newer than output")
+	}
+}
+
+func TestRebuildListing(t *testing.T) {
+	defer chdirTemp(t)()
+	defer func() { pages, pagesModTime = nil, time.Time{} }()
+
+	os.Mkdir(ZSDIR, 0755)
+	os.Mkdir(PUBDIR, 0755)
+	ioutil.WriteFile(ZSDIR+"/layout.amber", []byte("div #{unescaped(content)}"), 0644)
+	ioutil.WriteFile("index.amber", []byte("each $p in pages\n\tp #{$p.title}\n"), 0644)
+	ioutil.WriteFile("about.amber", []byte("p About"), 0644)
+	ioutil.WriteFile("a.md", []byte("title: A\n---\nA"), 0644)
+	rebuild([]string{"index.amber", "about.amber", "a.md"}, Vars{}, nil)
+
+	older, old := time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour)
+	for _, path := range []string{".", "index.amber", "about.amber", "a.md"} {
+		os.Chtimes(path, older, older)
+	}
+	for _, path := range []string{"index.html", "about.html", "a.html"} {
+		os.Chtimes(filepath.Join(PUBDIR, path), old, old)
+	}
+
+	// Only the new page is rebuilt in watch mode, but the index lists it
+	ioutil.WriteFile("b.md", []byte("title: B\n---\nB"), 0644)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes("b.md", future, future)
+	rebuild([]string{"b.md"}, Vars{}, nil)
+	if b, err := ioutil.ReadFile(filepath.Join(PUBDIR, "index.html")); err != nil || !strings.Contains(string(b), "<p>B</p>") {
+		t.Error(string(b), err)
+	}
+	if info, err := os.Stat(filepath.Join(PUBDIR, "about.html")); err != nil || !info.ModTime().Equal(old) {
+		t.Error("page not listing pages is rebuilt")
+	}
+}
+
+func TestNestedLayouts(t *testing.T) {
+	defer chdirTemp(t)()
+
+	os.Mkdir(ZSDIR, 0755)
+	files := map[string]string{
+		"base.amber":  "body\n\t#{unescaped(content)}\n",
+		"post.amber":  "layout: base.amber\n---\narticle\n\th1 #{title}\n\tdiv #{unescaped(content)}\n",
+		"a.amber":     "layout: b.amber\n---\np A\n",
+		"b.amber":     "layout: a.amber\n---\np B\n",
+		"../post.md":  "title: Hello\nlayout: post.amber\n---\nworld\n",
+		"../cycle.md": "layout: a.amber\n---\nworld\n",
+	}
+	for name, content := range files {
+		ioutil.WriteFile(filepath.Join(ZSDIR, name), []byte(content), 0644)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := buildMarkdown("post.md", buf, Vars{}); err != nil {
+		t.Error(err)
+	} else if s := buf.String(); s != "<body><article>\n\t<h1>Hello</h1>\n\t<div><p>world</p>\n</div>\n</article>\n</body>\n" {
+		t.Error(s)
+	}
+	if err := buildMarkdown("cycle.md", &bytes.Buffer{}, Vars{}); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Error(err)
+	}
+
+	// Pages are rebuilt when any of their layouts changes
+	ioutil.WriteFile("page.amber", []byte("layout: post.amber\n---\np Page\n"), 0644)
+	ioutil.WriteFile("page.html", []byte("layout: post.amber\n---\n<p>Page</p>\n"), 0644)
+	past := time.Now().Add(-time.Hour)
+	for _, name := range []string{"post.md", "page.amber", "page.html", "cycle.md", ZSDIR + "/base.amber", ZSDIR + "/post.amber", ZSDIR + "/a.amber", ZSDIR + "/b.amber"} {
+		os.Chtimes(name, past, past)
+	}
+	os.Mkdir(PUBDIR, 0755)
+	for _, name := range []string{"post.html", "page.html", "cycle.html"} {
+		ioutil.WriteFile(filepath.Join(PUBDIR, name), []byte{}, 0644)
+	}
+	for _, src := range []string{"post.md", "page.amber", "page.html", "cycle.md"} {
+		if needsRebuild(src, filepath.Join(PUBDIR, strings.TrimSuffix(src, filepath.Ext(src))+".html"), Vars{}) {
+			t.Error("up to date page is rebuilt:", src)
+		}
+	}
+	future := time.Now().Add(time.Hour)
+	os.Chtimes(ZSDIR+"/base.amber", future, future)
+	for _, src := range []string{"post.md", "page.amber", "page.html"} {
+		if !needsRebuild(src, filepath.Join(PUBDIR, strings.TrimSuffix(src, filepath.Ext(src))+".html"), Vars{}) {
+			t.Error("parent layout is newer than output:", src)
+		}
+	}
+}
+
+func TestInclude(t *testing.T) {
+	defer chdirTemp(t)()
+
+	os.Mkdir(ZSDIR, 0755)
+	files := map[string]string{
+		"footer.amber": "footer #{title}\n",
+		"note.html":    "<b>note</b>",
+		"loop.amber":   "i #{include(\"loop.amber\")}\n",
+	}
+	for name, content := range files {
+		ioutil.WriteFile(filepath.Join(ZSDIR, name), []byte(content), 0644)
+	}
+
+	tests := map[string]string{
+		`#{include("footer.amber")}`:  "<footer>Hello</footer>\n\n",
+		`#{include("note.html")}`:     "<b>note</b>\n",
+		`#{include("missing.amber")}`: "<!-- include missing.amber: open .zs/missing.amber: no such file or directory -->\n",
+	}
+	for script, expected := range tests {
+		ioutil.WriteFile("test.amber", []byte("title: Hello\n---\n"+script+"\n"), 0644)
+		buf := &bytes.Buffer{}
+		if err := buildAmber("test.amber", buf, Vars{}); err != nil {
+			t.Error(err)
+		} else if buf.String() != expected {
+			t.Error(script, buf.String())
+		}
+	}
+
+	ioutil.WriteFile("test.amber", []byte(`#{include("loop.amber")}`+"\n"), 0644)
+	buf := &bytes.Buffer{}
+	if err := buildAmber("test.amber", buf, Vars{}); err != nil {
+		t.Error(err)
+	} else if !strings.Contains(buf.String(), "too many nested includes") {
+		t.Error(buf.String())
+	}
+}
+
+func TestRelativeInclude(t *testing.T) {
+	defer chdirTemp(t)()
+
+	os.MkdirAll(filepath.Join(ZSDIR, "partials"), 0755)
+	os.Mkdir("blog", 0755)
+	files := map[string]string{
+		ZSDIR + "/partials/footer.html": "<footer>site</footer>",
+		ZSDIR + "/note.html":            "<b>site note</b>",
+		"blog/note.html":                "<b>blog note</b>",
+		"blog/card.amber":               "p #{title}\n",
+		"secret.html":                   "secret",
+	}
+	for name, content := range files {
+		ioutil.WriteFile(name, []byte(content), 0644)
+	}
+
+	tests := map[string]string{
+		`#{include("note.html")}`:            "<b>blog note</b>\n",
+		`#{include("card.amber")}`:           "<p>Hello</p>\n\n",
+		`#{include("partials/footer.html")}`: "<footer>site</footer>\n",
+		`#{include("../../secret.html")}`:    "<!-- include ../../secret.html: outside of the source directory -->\n",
+		`#{include("../secret.html")}`:       "secret\n",
+	}
+	for script, expected := range tests {
+		ioutil.WriteFile("blog/test.amber", []byte("title: Hello\n---\n"+script+"\n"), 0644)
+		buf := &bytes.Buffer{}
+		if err := buildAmber("blog/test.amber", buf, Vars{}); err != nil {
+			t.Error(err)
+		} else if buf.String() != expected {
+			t.Error(script, buf.String())
+		}
+	}
+}
+
+func TestPages(t *testing.T) {
+	defer chdirTemp(t)()
+
+	files := map[string]string{
+		"a.md":        "title: A\ndate: 2015-08-28\n---\nA",
+		"b.md":        "title: B\ndate: 2015-08-29\n---\nB",
+		"c.md":        "title: C\n---\nC",
+		"index.amber": "ul\n\teach $p in pages\n\t\tli #{$p.title} #{missing}\n",
+	}
+	for name, content := range files {
+		ioutil.WriteFile(name, []byte(content), 0644)
+	}
+	pages = collectPages(Vars{})
+	defer func() { pages = nil }()
+	buf := &bytes.Buffer{}
+	if err := buildAmber("index.amber", buf, Vars{}); err != nil {
+		t.Error(err)
+	} else if s := buf.String(); s != "<ul>\n\t<li>B </li>\n\t<li>A </li>\n\t<li>C </li>\n</ul>\n" {
+		t.Error(s)
+	}
+}
+
+func TestSrcPubDirs(t *testing.T) {
+	defer chdirTemp(t)()
+
+	os.MkdirAll(filepath.Join("src", "posts"), 0755)
+	os.MkdirAll(filepath.Join("src", ".hidden"), 0755)
+	files := map[string]string{
+		"src/index.amber":      "p Index",
+		"src/posts/a.md":       "title: A\nlayout: layout.html\n---\nA",
+		"src/.hidden/b.txt":    "B",
+		"src/.secret":          "C",
+		ZSDIR + "/layout.html": "",
+	}
+	os.Mkdir(ZSDIR, 0755)
+	for name, content := range files {
+		ioutil.WriteFile(name, []byte(content), 0644)
+	}
+
+	os.Setenv("ZS_SRCDIR", "src")
+	os.Setenv("ZS_PUBDIR", "public")
+	defer os.Unsetenv("ZS_SRCDIR")
+	defer os.Unsetenv("ZS_PUBDIR")
+	args := os.Args
+	os.Args = []string{"zs", "build"}
+	Main()
+	os.Args = args
+	defer func() { srcDir, pubDir = ".", PUBDIR }()
+
+	for path, exists := range map[string]bool{
+		"public/index.html":    true,
+		"public/posts/a.html":  true,
+		"public/.hidden/b.txt": false,
+		"public/.secret":       false,
+		PUBDIR:                 false,
+	} {
+		if _, err := os.Stat(path); (err == nil) != exists {
+			t.Error(path, exists, err)
+		}
+	}
+	if v, _, err := getVars("src/posts/a.md", Vars{}); err != nil {
+		t.Error(err)
+	} else if v["url"] != "posts/a.html" || v["output"] != filepath.Join("public", "posts", "a.html") {
+		t.Error(v)
+	}
+}
+
+func TestDrafts(t *testing.T) {
+	defer chdirTemp(t)()
+
+	os.Mkdir(ZSDIR, 0755)
+	os.Mkdir(PUBDIR, 0755)
+	files := map[string]string{
+		ZSDIR + "/layout.amber": "div #{unescaped(content)}",
+		"draft.md":              "draft: true\n---\nDraft",
+		"draft.amber":           "draft: true\n---\np Draft",
+		"post.md":               "draft: false\n---\nPost",
+		"draft.txt":             "draft: true\n---\nRaw",
+	}
+	for name, content := range files {
+		ioutil.WriteFile(name, []byte(content), 0644)
+	}
+	for _, name := range []string{"draft.md", "draft.amber", "post.md", "draft.txt"} {
+		if err := build(name, nil, Vars{}); err != nil {
+			t.Error(name, err)
+		}
+	}
+	for path, exists := range map[string]bool{
+		"draft.html": false,
+		"post.html":  true,
+		"draft.txt":  true,
+	} {
+		if _, err := os.Stat(filepath.Join(PUBDIR, path)); (err == nil) != exists {
+			t.Error(path, exists, err)
+		}
+	}
+
+	*drafts = true
+	defer func() { *drafts = false }()
+	if err := build("draft.md", nil, Vars{}); err != nil {
+		t.Error(err)
+	} else if _, err := os.Stat(filepath.Join(PUBDIR, "draft.html")); err != nil {
+		t.Error(err)
+	}
+
+	// Pages that become drafts are unpublished
+	ioutil.WriteFile("post.md", []byte("aliases: old.html\nformats: html, txt\n---\nPost"), 0644)
+	if err := build("post.md", nil, Vars{}); err != nil {
+		t.Fatal(err)
+	}
+	*drafts = false
+	ioutil.WriteFile("post.md", []byte("draft: true\naliases: old.html\nformats: html, txt\n---\nPost"), 0644)
+	if err := build("post.md", nil, Vars{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"post.html", "post.txt", "old.html"} {
+		if _, err := os.Stat(filepath.Join(PUBDIR, path)); err == nil {
+			t.Error("draft output published:", path)
+		}
+	}
+}
+
+func TestScheduled(t *testing.T) {
+	defer chdirTemp(t)()
+
+	os.Mkdir(ZSDIR, 0755)
+	os.Mkdir(PUBDIR, 0755)
+	tomorrow := time.Now().Add(24 * time.Hour).Format("2006-01-02")
+	files := map[string]string{
+		ZSDIR + "/layout.amber": "div #{unescaped(content)}",
+		"past.md":               "date: 2015-08-28\n---\nPast",
+		"future.md":             "date: " + tomorrow + "\n---\nFuture",
+	}
+	for name, content := range files {
+		ioutil.WriteFile(name, []byte(content), 0644)
+	}
+	for _, name := range []string{"past.md", "future.md"} {
+		if err := build(name, nil, Vars{}); err != nil {
+			t.Error(name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(PUBDIR, "past.html")); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(filepath.Join(PUBDIR, "future.html")); err == nil {
+		t.Error("future page has been built")
+	}
+
+	if paths := due(); len(paths) != 0 {
+		t.Error(paths)
+	}
+	scheduled.pages["future.md"] = time.Now()
+	if paths := due(); len(paths) != 1 || paths[0] != "future.md" {
+		t.Error(paths)
+	}
+
+	*future = true
+	defer func() { *future = false }()
+	if err := build("future.md", nil, Vars{}); err != nil {
+		t.Error(err)
+	} else if _, err := os.Stat(filepath.Join(PUBDIR, "future.html")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMinify(t *testing.T) {
+	defer chdirTemp(t)()
+
+	os.Mkdir(ZSDIR, 0755)
+	ioutil.WriteFile(ZSDIR+"/layout.amber", []byte("html\n\tbody\n\t\tdiv #{unescaped(content)}\n"), 0644)
+	ioutil.WriteFile("index.md", []byte("title: Index\n---\nHello\n\n    a  b\n      c\n"), 0644)
+	ioutil.WriteFile("style.gcss", []byte("body\n  color: red\n"), 0644)
+
+	for _, minify := range []string{"", "1"} {
+		html, css := &bytes.Buffer{}, &bytes.Buffer{}
+		vars := Vars{"minify": minify}
+		if err := build("index.md", html, vars); err != nil {
+			t.Fatal(err)
+		}
+		if err := build("style.gcss", css, vars); err != nil {
+			t.Fatal(err)
+		}
+		if minify == "" {
+			if !strings.Contains(html.String(), "<html>\n\t<body>") || css.String() != "body{color:red;}" {
+				t.Error(html.String(), css.String())
+			}
+			continue
+		}
+		if !strings.HasPrefix(html.String(), "<html><body><div><p>Hello</p>") ||
+			!strings.Contains(html.String(), "<pre><code>a  b\n  c\n</code></pre>") {
+			t.Error(html.String())
+		}
+		if css.String() != "body{color:red}" {
+			t.Error(css.String())
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	defer chdirTemp(t)()
+	defer func() { assets.paths = map[string]string{} }()
+
+	os.Mkdir(PUBDIR, 0755)
+	ioutil.WriteFile("style.gcss", []byte("body\n  color: red\n"), 0644)
+	ioutil.WriteFile("app.js", []byte("alert(1)"), 0644)
+	ioutil.WriteFile("index.amber", []byte("link[href=asset(\"/style.css\")]\nscript[src=asset(\"app.js\")]\nimg[src=asset(\"x.png\")]\n"), 0644)
+
+	rebuild([]string{"index.amber", "style.gcss", "app.js"}, Vars{"fingerprint": "1"}, nil)
+	b, err := ioutil.ReadFile(filepath.Join(PUBDIR, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "<link href=\"/style.a861ee8e.css\" />\n<script src=\"app.6e11c72f.js\"></script>\n<img src=\"x.png\" />\n"
+	if string(b) != expected {
+		t.Error(string(b))
+	}
+	for path, exists := range map[string]bool{
+		"style.css":          false,
+		"style.a861ee8e.css": true,
+		"app.js":             false,
+		"app.6e11c72f.js":    true,
+	} {
+		if _, err := os.Stat(filepath.Join(PUBDIR, path)); (err == nil) != exists {
+			t.Error(path, exists, err)
+		}
+	}
+	if err := cleanStale(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(PUBDIR, "style.a861ee8e.css")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	for s, slug := range map[string]string{
+		"Über-uns":         "uber-uns",
+		"Hello, World!":    "hello-world",
+		"  Crème brûlée  ": "creme-brulee",
+		"Straße_2015":      "strasse-2015",
+		"already-a-slug":   "already-a-slug",
+		"---":              "",
+	} {
+		if s := slugify(s); s != slug {
+			t.Error(s, slug)
+		}
+	}
+
+	defer chdirTemp(t)()
+
+	os.Mkdir("posts", 0755)
+	ioutil.WriteFile("posts/Über-uns.md", []byte("Hello\n"), 0644)
+	v, _, err := getVars("posts/Über-uns.md", Vars{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v["title"] != "Über Uns" || v["url"] != "posts/Über-uns.html" {
+		t.Error(v)
+	}
+	v, _, err = getVars("posts/Über-uns.md", Vars{"slugify": "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v["url"] != "posts/uber-uns.html" || v["output"] != filepath.Join(PUBDIR, "posts", "uber-uns.html") {
+		t.Error(v)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	defer chdirTemp(t)()
+
+	if v := globals(); v["title"] != "" {
+		t.Error(v)
+	}
+	os.Mkdir(ZSDIR, 0755)
+	ioutil.WriteFile(ZSDIR+"/config.yaml", []byte("title: My site\nURL: http://example.com\nminify: 1\nmenu:\n  - a\n  - b\n"), 0644)
+	os.Setenv("ZS_URL", "http://localhost")
+	defer os.Unsetenv("ZS_URL")
+	v := globals()
+	if v["title"] != "My site" || v["url"] != "http://localhost" || v["minify"] != "1" {
+		t.Error(v)
+	}
+	if _, ok := v["menu"]; ok {
+		t.Error(v)
+	}
+
+	ioutil.WriteFile(ZSDIR+"/config.yaml", []byte("title: [unclosed\n"), 0644)
+	if v := globals(); v["url"] != "http://localhost" || v["title"] != "" {
+		t.Error(v)
+	}
+}
+
+func TestDirDefaults(t *testing.T) {
+	defer chdirTemp(t)()
+	defer resetDefaults()
+
+	os.MkdirAll("blog/2015", 0755)
+	files := map[string]string{
+		DEFAULTS:              "author: me\nlayout: base.amber\nsection: home\n",
+		"blog/" + DEFAULTS:    "layout: post.amber\nsection: blog\n",
+		"blog/2015/post.md":   "section: news\n---\nPost",
+		"blog/2015/other.md":  "Other",
+		"index.md":            "Index",
+		ZSDIR + "/post.amber": "p #{author}",
+	}
+	os.Mkdir(ZSDIR, 0755)
+	for name, content := range files {
+		ioutil.WriteFile(name, []byte(content), 0644)
+	}
+
+	for path, expected := range map[string]Vars{
+		"blog/2015/post.md":  {"author": "me", "layout": "post.amber", "section": "news", "site": "zs"},
+		"blog/2015/other.md": {"author": "me", "layout": "post.amber", "section": "blog", "site": "zs"},
+		"index.md":           {"author": "me", "layout": "base.amber", "section": "home", "site": "zs"},
+	} {
+		v, _, err := getVars(path, Vars{"site": "zs", "section": "global"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for key, value := range expected {
+			if v[key] != value {
+				t.Error(path, key, v[key], value)
+			}
+		}
+	}
+	if !hidden("blog/" + DEFAULTS) {
+		t.Error("defaults file is published")
+	}
+	if files := defaultFiles("blog/2015/post.md"); len(files) != 2 || files[0] != DEFAULTS {
+		t.Error(files)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	defer chdirTemp(t)()
+
+	os.Mkdir("posts", 0755)
+	ioutil.WriteFile("index.amber", []byte("p Index"), 0644)
+	ioutil.WriteFile("posts/a.md", []byte("title: A\ndate: 2015-08-28\n---\nA"), 0644)
+	os.Mkdir(ZSDIR, 0755)
+	ioutil.WriteFile(ZSDIR+"/layout.amber", []byte("div #{unescaped(content)}"), 0644)
+
+	*dryRun = true
+	defer func() { *dryRun = false }()
+	NewSite().Build()
+	if _, err := os.Stat(PUBDIR); err == nil {
+		t.Error("output directory created")
+	}
+
+	*dryRun = false
+	NewSite().Build()
+	ioutil.WriteFile(filepath.Join(PUBDIR, "stale.html"), []byte{}, 0644)
+	os.Mkdir("drafts", 0755)
+	*dryRun = true
+	if err := cleanStale(); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(filepath.Join(PUBDIR, "drafts")); err == nil {
+		t.Error("output directory created by clean")
+	}
+	if err := clean(); err != nil {
+		t.Error(err)
+	}
+	for _, path := range []string{"index.html", "posts/a.html", "rss.xml", "stale.html"} {
+		if _, err := os.Stat(filepath.Join(PUBDIR, path)); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestNoLayout(t *testing.T) {
+	defer chdirTemp(t)()
+
+	os.Mkdir(ZSDIR, 0755)
+	ioutil.WriteFile(filepath.Join(ZSDIR, "layout.amber"), []byte("div #{unescaped(content)}\n"), 0644)
+	body := "# Hello\n\nSome *text*.\n"
+	for header, expected := range map[string]string{
+		"layout: none\n---\n": markdown(body, Vars{}),
+		"layout: \"\"\n---\n": markdown(body, Vars{}),
+		"":                    "<div>" + markdown(body, Vars{}) + "</div>\n",
+	} {
+		ioutil.WriteFile("page.md", []byte(header+body), 0644)
+		buf := &bytes.Buffer{}
+		if err := buildMarkdown("page.md", buf, Vars{}); err != nil {
+			t.Error(header, err)
+		} else if buf.String() != expected {
+			t.Error(header, buf.String())
+		}
+	}
+}
+
+func TestContentAlias(t *testing.T) {
+	defer chdirTemp(t)()
+
+	os.Mkdir(ZSDIR, 0755)
+	ioutil.WriteFile(filepath.Join(ZSDIR, "layout.amber"), []byte("div #{unescaped(content)}\nsection #{unescaped(__content)}\n"), 0644)
+	ioutil.WriteFile("page.md", []byte("Hello\n"), 0644)
+	ioutil.WriteFile("page.html", []byte("layout: layout.amber\n---\n<p>Hello</p>"), 0644)
+
+	for path, expected := range map[string]string{
+		"page.md":   "<div><p>Hello</p>\n</div>\n<section><p>Hello</p>\n</section>\n",
+		"page.html": "<div><p>Hello</p></div>\n<section><p>Hello</p></section>\n",
+	} {
+		buf := &bytes.Buffer{}
+		if err := build(path, buf, Vars{}); err != nil {
+			t.Error(path, err)
+		} else if buf.String() != expected {
+			t.Error(path, buf.String())
+		}
+	}
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	defer chdirTemp(t)()
+
+	os.Mkdir(ZSDIR, 0755)
+	ioutil.WriteFile(filepath.Join(ZSDIR, "layout.amber"), []byte("h1 #{markdownify(title)}\np #{truncate(description, 20)}\n"), 0644)
+	ioutil.WriteFile("page.md", []byte("title: Hello *world*\n---\nA long description of the page, with more words.\n"), 0644)
+	ioutil.WriteFile("page.html", []byte("title: Hello\n---\n{{ markdownify \"**bold**\" }} {{ truncate .title 3 }}"), 0644)
+
+	for path, expected := range map[string]string{
+		"page.md":   "<h1>Hello <em>world</em></h1>\n<p>A long description…</p>\n",
+		"page.html": "<strong>bold</strong> Hel…",
+	} {
+		buf := &bytes.Buffer{}
+		if err := build(path, buf, Vars{}); err != nil {
+			t.Error(path, err)
+		} else if buf.String() != expected {
+			t.Error(path, buf.String())
+		}
+	}
+}
+
+func TestHTMLTemplates(t *testing.T) {
+	defer chdirTemp(t)()
+
+	os.Mkdir(ZSDIR, 0755)
+	files := map[string]string{
+		"page.html":           "title: Hello\n---\n<h1>{{ .title }}</h1>\n<p>{{ .missing }}</p>\n",
+		"wrapped.html":        "title: Wrapped\nlayout: base.amber\n---\n<p>{{ .title }}</p>",
+		"raw.html":            "<script>var t = '{{ x }}';</script>\n",
+		ZSDIR + "/base.amber": "div #{unescaped(content)}",
+	}
+	for name, content := range files {
+		ioutil.WriteFile(name, []byte(content), 0644)
+	}
+	for path, expected := range map[string]string{
+		"page.html":    "<h1>Hello</h1>\n<p></p>\n",
+		"wrapped.html": "<div><p>Wrapped</p></div>\n",
+		"raw.html":     "<script>var t = '{{ x }}';</script>\n",
+	} {
+		buf := &bytes.Buffer{}
+		if err := build(path, buf, Vars{}); err != nil {
+			t.Error(path, err)
+		} else if buf.String() != expected {
+			t.Error(path, buf.String())
+		}
+	}
+}
+
+func TestRawCopy(t *testing.T) {
+	defer chdirTemp(t)()
+
+	os.Mkdir(PUBDIR, 0755)
+	ioutil.WriteFile("deploy.sh", []byte("#!/bin/sh\necho hello\n"), 0755)
+	mtime := time.Date(2015, 8, 28, 12, 0, 0, 0, time.UTC)
+	os.Chtimes("deploy.sh", mtime, mtime)
+
+	if err := build("deploy.sh", nil, Vars{}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(PUBDIR, "deploy.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0755 {
+		t.Error(info.Mode())
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Error(info.ModTime())
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(PUBDIR, "deploy.sh")); string(b) != "#!/bin/sh\necho hello\n" {
+		t.Error(string(b))
+	}
+
+	// Read-only sources give writable outputs, so they can be rebuilt
+	ioutil.WriteFile("readonly.txt", []byte("v1"), 0444)
+	if err := build("readonly.txt", nil, Vars{}); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filepath.Join(PUBDIR, "readonly.txt")); err != nil || info.Mode().Perm() != 0644 {
+		t.Error(info, err)
+	}
+	os.Chmod("readonly.txt", 0644)
+	ioutil.WriteFile("readonly.txt", []byte("v2"), 0444)
+	if err := build("readonly.txt", nil, Vars{}); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(PUBDIR, "readonly.txt")); string(b) != "v2" {
+		t.Error(string(b))
+	}
+}
+
+func TestWalkRoot(t *testing.T) {
+	defer chdirTemp(t)()
+
+	for _, path := range []string{"index.md", "posts/a.md", ".hidden", ".git/config",
+		ZSDIR + "/layout.amber", PUBDIR + "/index.html"} {
+		os.MkdirAll(filepath.Dir(path), 0755)
+		ioutil.WriteFile(path, []byte{}, 0644)
+	}
+	if hidden(".") || hidden("./index.md") || !hidden("./.hidden") || !hidden(ZSDIR) || !hidden(PUBDIR) {
+		t.Error("wrong hidden files")
+	}
+	dirs, paths := walk(".", time.Unix(0, 0))
+	if strings.Join(dirs, " ") != ". posts" {
+		t.Error(dirs)
+	}
+	if strings.Join(paths, " ") != "index.md "+filepath.Join("posts", "a.md") {
+		t.Error(paths)
+	}
+}
+
+func TestMarkdownExtensions(t *testing.T) {
+	defer chdirTemp(t)()
+	defer func() { mdExts = defaultMdExts }()
+
+	os.Mkdir(ZSDIR, 0755)
+	ioutil.WriteFile(ZSDIR+"/layout.amber", []byte("div #{unescaped(content)}"), 0644)
+	for _, name := range []string{"a.md", "b.mkd", "c.markdown", "d.txt"} {
+		ioutil.WriteFile(name, []byte("*Hello*"), 0644)
+	}
+
+	for path, expected := range map[string]string{
+		"a.md":       "<div><p><em>Hello</em></p>\n</div>\n",
+		"b.mkd":      "<div><p><em>Hello</em></p>\n</div>\n",
+		"c.markdown": "<div><p><em>Hello</em></p>\n</div>\n",
+		"d.txt":      "*Hello*",
+	} {
+		buf := &bytes.Buffer{}
+		if err := build(path, buf, Vars{}); err != nil {
+			t.Error(path, err)
+		} else if buf.String() != expected {
+			t.Error(path, buf.String())
+		}
+	}
+
+	os.Setenv("ZS_MD_EXT", "txt, .md")
+	defer os.Unsetenv("ZS_MD_EXT")
+	args := os.Args
+	os.Args = []string{"zs", "build"}
+	Main()
+	os.Args = args
+	for path, exists := range map[string]bool{
+		"a.html":     true,
+		"d.html":     true,
+		"c.markdown": true,
+		"c.html":     false,
+	} {
+		if _, err := os.Stat(filepath.Join(PUBDIR, path)); (err == nil) != exists {
+			t.Error(path, exists, err)
+		}
+	}
+}
+
+func TestPrettyURLs(t *testing.T) {
+	defer chdirTemp(t)()
+
+	os.Mkdir("blog", 0755)
+	os.Mkdir(ZSDIR, 0755)
+	ioutil.WriteFile(ZSDIR+"/layout.amber", []byte("a[href=root+\"style.css\"] #{url}"), 0644)
+	for _, name := range []string{"index.md", "about.md", "blog/index.md", "blog/post.md"} {
+		ioutil.WriteFile(name, []byte("Hello"), 0644)
+	}
+
+	vars := Vars{"pretty_urls": "1"}
+	for path, expected := range map[string]Vars{
+		"index.md":      {"url": "", "root": "", "output": filepath.Join(PUBDIR, "index.html")},
+		"about.md":      {"url": "about/", "root": "../", "output": filepath.Join(PUBDIR, "about", "index.html")},
+		"blog/index.md": {"url": "blog/", "root": "../", "output": filepath.Join(PUBDIR, "blog", "index.html")},
+		"blog/post.md":  {"url": "blog/post/", "root": "../../", "output": filepath.Join(PUBDIR, "blog", "post", "index.html")},
+	} {
+		v, _, err := getVars(path, vars)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for key, value := range expected {
+			if v[key] != value {
+				t.Error(path, key, v[key], value)
+			}
+		}
+		if err := build(path, nil, vars); err != nil {
+			t.Error(path, err)
+		}
+		b, err := ioutil.ReadFile(expected["output"])
+		if err != nil {
+			t.Error(err)
+		} else if s := string(b); s != "<a href=\""+expected["root"]+"style.css\">"+expected["url"]+"</a>\n" {
+			t.Error(path, s)
+		}
+	}
+
+	os.Setenv("ZS_PRETTY_URLS", "1")
+	defer os.Unsetenv("ZS_PRETTY_URLS")
+	if err := cleanStale(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(PUBDIR, "blog", "post", "index.html")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBuildOutput(t *testing.T) {
+	defer chdirTemp(t)()
+	defer func() { *output = "" }()
+
+	ioutil.WriteFile("index.amber", []byte("p Hello"), 0644)
+	args := os.Args
+	defer func() { os.Args = args }()
+
+	os.Args = []string{"zs", "build", "index.amber", "-o", "out/page.html"}
+	Main()
+	if b, err := ioutil.ReadFile(filepath.Join("out", "page.html")); err != nil {
+		t.Error(err)
+	} else if string(b) != "<p>Hello</p>\n" {
+		t.Error(string(b))
+	}
+	if _, err := os.Stat(PUBDIR); err == nil {
+		t.Error("site built")
+	}
+
+	code := 0
+	osExit = func(c int) { code = c }
+	defer func() { osExit = os.Exit }()
+	os.Args = []string{"zs", "build", "-o", "out/site.html"}
+	Main()
+	if _, err := os.Stat(PUBDIR); err == nil {
+		t.Error("site built with -o")
+	}
+	if code != 1 {
+		t.Error("exit status", code)
+	}
+	code = 0
+	os.Args = []string{"zs", "unknown"}
+	Main()
+	if code != 1 {
+		t.Error("exit status of unknown command", code)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	defer chdirTemp(t)()
+
+	os.Mkdir(ZSDIR, 0755)
+	os.Mkdir(PUBDIR, 0755)
+	ioutil.WriteFile("index.amber", []byte("p Hello"), 0644)
+	ioutil.WriteFile(ZSDIR+"/prebuild", []byte("#!/bin/sh\necho \"pre $ZS_TITLE $HOME\" > hooks.log\ntest -f .pub/index.html && echo built >> hooks.log\nexit 0\n"), 0755)
+	ioutil.WriteFile(ZSDIR+"/postbuild", []byte("#!/bin/sh\ntest -f .pub/index.html && echo post >> hooks.log\nexit 1\n"), 0755)
+
+	rebuild([]string{"index.amber"}, Vars{"title": "Site"}, nil)
+	b, err := ioutil.ReadFile("hooks.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "pre Site " + os.Getenv("HOME") + "\npost\n"; string(b) != expected {
+		t.Error(string(b))
+	}
+
+	// Up to date files don't run the postbuild hook
+	changes := 0
+	rebuild([]string{"index.amber"}, Vars{"title": "Site"}, func() { changes++ })
+	if b, _ := ioutil.ReadFile("hooks.log"); strings.Contains(string(b), "post") || changes != 0 {
+		t.Error(string(b), changes)
+	}
+}
+
+func TestPrependPath(t *testing.T) {
+	sep := string(os.PathListSeparator)
+	if p := prependPath(ZSDIR, "a"+sep+"b"); p != ZSDIR+sep+"a"+sep+"b" {
+		t.Error(p)
+	}
+	if p := prependPath(ZSDIR, ""); p != ZSDIR {
+		t.Error(p)
+	}
+	if list := filepath.SplitList(prependPath(ZSDIR, "a")); len(list) != 2 || list[0] != ZSDIR {
+		t.Error(list)
+	}
+}
+
+func TestScript(t *testing.T) {
+	defer chdirTemp(t)()
+
+	os.MkdirAll(filepath.Join(ZSDIR, "postbuild"), 0755)
+	ioutil.WriteFile(filepath.Join(ZSDIR, "prebuild.bat"), []byte("echo pre"), 0755)
+	ioutil.WriteFile(filepath.Join(ZSDIR, "postbuild.cmd"), []byte("echo post"), 0755)
+
+	exts := []string{"", ".exe", ".bat", ".cmd"}
+	if path := script("prebuild", exts); path != filepath.Join(ZSDIR, "prebuild.bat") {
+		t.Error(path)
+	}
+	if path := script("postbuild", exts); path != filepath.Join(ZSDIR, "postbuild.cmd") {
+		t.Error(path)
+	}
+	if path := script("prebuild", []string{""}); path != "" {
+		t.Error(path)
+	}
+}
+
+func TestBuildFilesCount(t *testing.T) {
+	defer chdirTemp(t)()
+
+	os.Mkdir(PUBDIR, 0755)
+	ioutil.WriteFile("a.txt", []byte("a"), 0644)
+	ioutil.WriteFile("b.txt", []byte("b"), 0644)
+	n, errs := buildFiles([]string{"a.txt", "b.txt", "missing.txt"}, Vars{})
+	if n != 2 || len(errs) != 1 {
+		t.Error(n, errs)
+	}
+	// Up to date files are not counted
+	if n, errs := buildFiles([]string{"a.txt", "b.txt"}, Vars{}); n != 0 || len(errs) != 0 {
+		t.Error(n, errs)
+	}
+}
+
+func TestEnv(t *testing.T) {
+	defer chdirTemp(t)()
+
+	os.Setenv("ZS_MINIFY", "1")
+	defer os.Unsetenv("ZS_MINIFY")
+	v := globals()
+	if v["env"] != PRODUCTION || v["minify"] != "1" || isDev(v) {
+		t.Error(v)
+	}
+	if !isDraft(Vars{"draft": "true", "env": PRODUCTION}) {
+		t.Error("draft published in production")
+	}
+
+	os.Setenv("ZS_ENV", "staging")
+	defer os.Unsetenv("ZS_ENV")
+	if v := globals(); v["env"] != "staging" || v["minify"] != "0" {
+		t.Error(v)
+	}
+
+	*env = "dev"
+	defer func() { *env = "" }()
+	v = globals()
+	if v["env"] != "dev" || v["minify"] != "0" || v["fingerprint"] != "0" || !isDev(v) {
+		t.Error(v)
+	}
+	if isDraft(Vars{"draft": "true", "env": "dev"}) {
+		t.Error("draft not built in dev")
+	}
+}
+
+func TestHeaderError(t *testing.T) {
+	for content, expected := range map[string]string{
+		"title: Post\nbad: line: here\n---\nText":  `blog/post.md:2: failed to parse header: yaml: line 1: mapping values are not allowed in this context: "bad: line: here"`,
+		"+++\ntitle = \"Post\"\nbad = \n+++\nText": "blog/post.md:3: failed to parse header: Near line 3",
+		"title: Post\n[a, b]: Post\n---\nText":     "blog/post.md:2: failed to parse header: yaml: unmarshal errors:\n  line 2: cannot unmarshal !!seq into string: \"[a, b]: Post\"",
+	} {
+		_, _, err := parseVars("blog/post.md", content, Vars{})
+		if err == nil {
+			t.Error(content)
+		} else if !strings.HasPrefix(err.Error(), expected) {
+			t.Error(err)
+		}
+	}
+}
+
+func TestNotFoundPage(t *testing.T) {
+	defer chdirTemp(t)()
+
+	os.Mkdir("blog", 0755)
+	ioutil.WriteFile("404.md", []byte("draft: true\ndate: 2100-01-01\n---\nNot found"), 0644)
+	ioutil.WriteFile("blog/404.md", []byte("draft: true\n---\nDraft"), 0644)
+
+	v, _, err := getVars("404.md", Vars{"pretty_urls": "1", "layout": "base.amber"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v["url"] != "404.html" || v["layout"] != "base.amber" || isDraft(v) || isScheduled(v) {
+		t.Error(v)
+	}
+	if v, _, _ := getVars("blog/404.md", Vars{}); !isDraft(v) || v["layout"] != "layout.html" {
+		t.Error(v)
+	}
+}
+
+func TestYAMLHeader(t *testing.T) {
+	vars, body, err := splitHeader("title: Post\nversion: 1.10\ndate: 2015-08-28\ntags: [go, web]\nauthor:\n  name: Me\nempty:\n---\nText")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Vars{"title": "Post", "version": "1.10", "date": "2015-08-28", "tags": "go, web", "author.name": "Me", "empty": ""}
+	if body != "Text" || len(vars) != len(expected) {
+		t.Error(vars, body)
+	}
+	for name, value := range expected {
+		if vars[name] != value {
+			t.Error(name, vars[name])
+		}
+	}
+}
+
+func TestJSONHeader(t *testing.T) {
+	for s, expected := range map[string]struct {
+		vars Vars
+		body string
+	}{
+		`{"title": "Post {1}", "author": {"name": "Me", "age": 42}, "tags": ["go", "web"], "draft": false}` + "\nText\n": {
+			Vars{"title": "Post {1}", "author.name": "Me", "author.age": "42", "tags": "go, web", "draft": "false"}, "Text\n"},
+		`{"title": "Only \"header\""}`:    {Vars{"title": `Only "header"`}, ""},
+		"{\"title\": \"Sep\"}\n---\nText": {Vars{"title": "Sep"}, "Text"},
+		"{{ .title }}\n":                  {Vars{}, "{{ .title }}\n"},
+	} {
+		vars, body, err := splitHeader(s)
+		if err != nil {
+			t.Error(s, err)
+			continue
+		}
+		if body != expected.body || len(vars) != len(expected.vars) {
+			t.Error(s, vars, body)
+		}
+		for key, value := range expected.vars {
+			if vars[key] != value {
+				t.Error(s, key, vars[key])
+			}
+		}
+	}
+}
+
+func TestVersion(t *testing.T) {
+	if s := versionString(); s != "zs dev (commit unknown, built unknown)" {
+		t.Error(s)
+	}
+	version = "1.2.3"
+	defer func() { version = "dev" }()
+	if v := globals(); v["generator"] != "zs 1.2.3" {
+		t.Error(v)
+	}
+}
+
+func TestExpandVars(t *testing.T) {
+	v, _, err := parseVars("blog/post.md", "canonical: \"{{url}}\"\nbanner: \"{{ root }}{{cdn}}/banner.png\"\na: \"{{b}}\"\nb: \"{{a}}\"\nself: \"x{{self}}\"\nmissing: \"{{nope}}\"\n---\nHello\n",
+		Vars{"cdn": "assets", "raw": "{{cdn}}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{
+		"canonical": "blog/post.html",
+		"banner":    "../assets/banner.png",
+		"raw":       "{{cdn}}",
+		"a":         "{{a}}",
+		"b":         "{{b}}",
+		"self":      "x{{self}}",
+		"missing":   "{{nope}}",
+	} {
+		if v[name] != expected {
+			t.Error(name, v[name])
+		}
+	}
+
+	// The page content given to the layout is never expanded
+	defer chdirTemp(t)()
+	os.Mkdir(ZSDIR, 0755)
+	ioutil.WriteFile(filepath.Join(ZSDIR, "layout.amber"), []byte("note: \"{{title}}\"\n---\ndiv #{note}\ndiv #{unescaped(content)}\n"), 0644)
+	ioutil.WriteFile("page.md", []byte("title: Secret\n---\nUse `{{title}}` in templates\n"), 0644)
+	buf := &bytes.Buffer{}
+	if err := buildMarkdown("page.md", buf, Vars{}); err != nil {
+		t.Fatal(err)
+	}
+	if s := buf.String(); !strings.Contains(s, "<code>{{title}}</code>") || !strings.Contains(s, "<div>Secret</div>") {
+		t.Error(s)
+	}
+}
+
+func TestTemplateError(t *testing.T) {
+	defer chdirTemp(t)()
+
+	os.Mkdir(ZSDIR, 0755)
+	ioutil.WriteFile(filepath.Join(ZSDIR, "bad.amber"), []byte("title: Bad\n---\ndiv\n\tp\n  span\n"), 0644)
+	ioutil.WriteFile(filepath.Join(ZSDIR, "layout.amber"), []byte("div #{title}\n"), 0644)
+	ioutil.WriteFile("a.md", []byte("layout: bad.amber\n---\nA\n"), 0644)
+	ioutil.WriteFile("b.md", []byte("B\n"), 0644)
+
+	err := buildMarkdown("a.md", ioutil.Discard, Vars{})
+	expected := `.zs/bad.amber:4: failed to compile template: Mismatching indentation. Please use a coherent indent schema.: "\tp"`
+	if err == nil || err.Error() != expected {
+		t.Error(err)
+	}
+	if n, errs := buildFiles([]string{"a.md", "b.md"}, Vars{}); n != 1 || len(errs) != 1 {
+		t.Error(n, errs)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(PUBDIR, "b.html")); err != nil || string(b) != "<div>B</div>\n" {
+		t.Error(string(b), err)
+	}
+}
+
+func TestNormalizeOutput(t *testing.T) {
+	defer chdirTemp(t)()
+
+	ioutil.WriteFile("page.html", []byte("title: Hi\n---\n<h1>{{ .title }}</h1>\r\n<p>Text</p>\r\n\r\n\r\n"), 0644)
+	for vars, expected := range map[string]string{
+		"1": "<h1>Hi</h1>\n<p>Text</p>\n",
+		"0": "<h1>Hi</h1>\r\n<p>Text</p>\r\n\r\n\r\n",
+	} {
+		buf := &bytes.Buffer{}
+		if err := buildHTML("page.html", buf, Vars{"normalize_output": vars}); err != nil {
+			t.Fatal(err)
+		} else if buf.String() != expected {
+			t.Errorf("%q", buf.String())
+		}
+	}
+	if s := string(normalize([]byte("a {}"), Vars{"normalize_output": "1"})); s != "a {}\n" {
+		t.Error(s)
+	}
+}
+
+func TestBaseURL(t *testing.T) {
+	os.Setenv("ZS_BASEURL", "/docs")
+	defer os.Unsetenv("ZS_BASEURL")
+	g := globals()
+	if g["baseurl"] != "/docs/" {
+		t.Error(g["baseurl"])
+	}
+	v, _, err := parseVars("blog/post.md", "title: Post\n---\nText", pageGlobals(g))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v["link"] != "/docs/blog/post.html" || v["url"] != "blog/post.html" {
+		t.Error(v)
+	}
+	if v, _, _ := parseVars("about.md", "Text", Vars{}); v["link"] != "/about.html" {
+		t.Error(v)
+	}
+
+	s := `<link href="/style.css" /><a href='/'>Home</a><a href="/docs/a.html"></a>` +
+		`<img src=/logo.png><script src="//cdn.example.com/x.js"></script><a href="b.html"></a>`
+	expected := `<link href="/docs/style.css" /><a href='/docs/'>Home</a><a href="/docs/a.html"></a>` +
+		`<img src=/docs/logo.png><script src="//cdn.example.com/x.js"></script><a href="b.html"></a>`
+	if r := rewriteLinks(s, "/docs/"); r != expected {
+		t.Error(r)
+	}
+	if r := rewriteLinks(`<a href="/a.html">`, "https://example.com/docs"); r != `<a href="https://example.com/docs/a.html">` {
+		t.Error(r)
+	}
+	buf := &bytes.Buffer{}
+	writeHTML(buf, bytes.NewBufferString(`<link href="/style.css">`), Vars{"baseurl": "/docs/"})
+	if buf.String() != `<link href="/style.css">` {
+		t.Error(buf.String())
+	}
+	buf.Reset()
+	writeHTML(buf, bytes.NewBufferString(`<link href="/style.css">`), Vars{"baseurl": "/docs/", "baseurl_rewrite": "1"})
+	if buf.String() != `<link href="/docs/style.css">` {
+		t.Error(buf.String())
+	}
+}
+
+func TestSince(t *testing.T) {
+	defer chdirTemp(t)()
+
+	old, cutoff := time.Unix(1440763200, 0), time.Unix(1440849600, 0)
+	ioutil.WriteFile("old.txt", []byte("old"), 0644)
+	ioutil.WriteFile("new.txt", []byte("new"), 0644)
+	ioutil.WriteFile("deploy", []byte{}, 0644)
+	os.Chtimes("old.txt", old, old)
+	os.Chtimes("deploy", cutoff, cutoff)
+
+	for s, expected := range map[string]time.Time{
+		"":                          time.Unix(0, 0),
+		"@1440849600":               cutoff,
+		"2015-08-29T12:00:00+00:00": cutoff,
+		"deploy":                    cutoff,
+	} {
+		if t2, err := parseSince(s); err != nil || !t2.Equal(expected) {
+			t.Error(s, t2, err)
+		}
+	}
+	for _, s := range []string{"@now", "missing"} {
+		if _, err := parseSince(s); err == nil {
+			t.Error(s)
+		}
+	}
+
+	*since = "@1440849600"
+	defer func() { *since = "" }()
+	(&Site{SrcDir: ".", PubDir: PUBDIR, Vars: Vars{}}).Build()
+	if _, err := os.Stat(filepath.Join(PUBDIR, "new.txt")); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(filepath.Join(PUBDIR, "old.txt")); err == nil {
+		t.Error("old file built")
+	}
+}
+
+func BenchmarkRenderAmber(b *testing.B) {
+	defer chdirTemp(b)()
+
+	os.Mkdir(ZSDIR, 0755)
+	ioutil.WriteFile(filepath.Join(ZSDIR, "base.amber"), []byte("body\n\t#{unescaped(content)}\n"), 0644)
+	ioutil.WriteFile("page.amber", []byte("layout: base.amber\n---\ndiv\n"+strings.Repeat("\tp #{title} and some text of a big generated page\n", 5000)), 0644)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := renderAmber("page.amber", ioutil.Discard, Vars{}, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}